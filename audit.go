@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// The actions an AuditEntry records.
+const (
+	auditCreate  = "create"
+	auditUpdate  = "update"
+	auditDelete  = "delete"
+	auditRestore = "restore"
+	auditImport  = "import"
+)
+
+// AuditEntry records one change to the movie collection. Before is nil for
+// a create and After for an import, which replaces everything at once and
+// names no single movie.
+type AuditEntry struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"requestId"`
+	// Actor identifies the API key the change was made with, or is empty
+	// when API keys are off.
+	Actor   string `json:"actor,omitempty"`
+	Action  string `json:"action"`
+	MovieID string `json:"movieId,omitempty"`
+	Before  *Movie `json:"before,omitempty"`
+	After   *Movie `json:"after,omitempty"`
+}
+
+// AuditLog is the append-only record of changes behind GET /audit.
+type AuditLog interface {
+	Append(entry AuditEntry) error
+	// List returns every entry, oldest first.
+	List() ([]AuditEntry, error)
+}
+
+// memoryAuditLog keeps entries in a slice, so they are lost on restart.
+type memoryAuditLog struct {
+	mu      sync.RWMutex
+	entries []AuditEntry
+}
+
+func newMemoryAuditLog() *memoryAuditLog {
+	return &memoryAuditLog{}
+}
+
+func (l *memoryAuditLog) Append(entry AuditEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, entry)
+	return nil
+}
+
+func (l *memoryAuditLog) List() ([]AuditEntry, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	out := make([]AuditEntry, len(l.entries))
+	copy(out, l.entries)
+	return out, nil
+}
+
+// fileAuditLog appends entries to a file as JSON lines. The file is only
+// ever opened for appending, so existing entries can't be rewritten
+// through it.
+type fileAuditLog struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+// newFileAuditLog opens the audit log at path, creating it if needed.
+func newFileAuditLog(path string) (*fileAuditLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &fileAuditLog{path: path, file: f}, nil
+}
+
+func (l *fileAuditLog) Append(entry AuditEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.file.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+func (l *fileAuditLog) List() ([]AuditEntry, error) {
+	f, err := os.Open(l.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	entries := []AuditEntry{}
+	dec := json.NewDecoder(f)
+	for {
+		var entry AuditEntry
+		err := dec.Decode(&entry)
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// Close closes the underlying file.
+func (l *fileAuditLog) Close() error {
+	return l.file.Close()
+}
+
+type actorKey struct{}
+
+// withActor records in ctx which API key authorized the request. Only a
+// hash of the key is kept, so the audit log can't leak working keys.
+func withActor(ctx context.Context, key string) context.Context {
+	sum := sha256.Sum256([]byte(key))
+	return context.WithValue(ctx, actorKey{}, "key:"+hex.EncodeToString(sum[:4]))
+}
+
+// actorFromContext returns the actor withActor stored in ctx, or "".
+func actorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// record appends an audit entry for a change r made, which also moves
+// lastModified on and tells event subscribers and webhooks. The change
+// has already happened by now, so a failure to write the entry is logged
+// rather than failing the request.
+func (h *handler) record(r *http.Request, action, id string, before, after *Movie) {
+	h.touch()
+	now := time.Now().UTC()
+	event := MovieEvent{Type: action, MovieID: id}
+	if after != nil {
+		// Subscribers and webhooks encode the movie later, after the
+		// handler is done with after.
+		movie := *after
+		event.Movie = &movie
+	}
+	h.events.publish(event)
+	h.webhooks.dispatch(WebhookEvent{Type: action, Movie: event.Movie, Timestamp: now})
+	err := h.audit.Append(AuditEntry{
+		Time:      now,
+		RequestID: requestIDFromContext(r.Context()),
+		Actor:     actorFromContext(r.Context()),
+		Action:    action,
+		MovieID:   id,
+		Before:    before,
+		After:     after,
+	})
+	if err != nil {
+		slog.Error("write audit entry",
+			"request_id", requestIDFromContext(r.Context()),
+			"action", action,
+			"movie_id", id,
+			"error", err,
+		)
+	}
+}
+
+// getAudit returns the audit log, oldest entry first, optionally only the
+// entries for ?movie_id=.
+func (h *handler) getAudit(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	entries, err := h.audit.List()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if id := r.URL.Query().Get("movie_id"); id != "" {
+		matching := []AuditEntry{}
+		for _, entry := range entries {
+			if entry.MovieID == id {
+				matching = append(matching, entry)
+			}
+		}
+		entries = matching
+	}
+	newJSONEncoder(w, r).Encode(entries)
+}
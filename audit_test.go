@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	audit, err := newFileAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer audit.Close()
+	cfg := testConfig()
+	cfg.APIKeys = []string{"secret"}
+	ts := newTestServer(t, cfg, WithAuditLog(audit))
+
+	resp := ts.do("POST", "/v1/movies", movieJSON("Heat"), "X-API-Key", "secret", "X-Request-ID", "req-create")
+	expectStatus(t, resp, http.StatusCreated)
+	movie := decodeBody[Movie](t, resp)
+	expectStatus(t, ts.do("POST", "/v1/movies", movieJSON("Ronin"), "X-API-Key", "secret"), http.StatusCreated)
+	expectStatus(t, ts.do("DELETE", "/v1/movies/"+movie.ID, "", "X-API-Key", "secret", "X-Request-ID", "req-delete"), http.StatusOK)
+
+	resp = ts.do("GET", "/v1/audit?movie_id="+movie.ID, "")
+	expectStatus(t, resp, http.StatusOK)
+	entries := decodeBody[[]AuditEntry](t, resp)
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries for %s, want a create and a delete: %+v", len(entries), movie.ID, entries)
+	}
+	actor := actorFromContext(withActor(context.Background(), "secret"))
+	create, del := entries[0], entries[1]
+	if create.Action != auditCreate || create.RequestID != "req-create" || create.Actor != actor ||
+		create.Before != nil || create.After == nil || create.After.Title != "Heat" || create.Time.IsZero() {
+		t.Errorf("create entry = %+v", create)
+	}
+	if del.Action != auditDelete || del.RequestID != "req-delete" || del.Actor != actor ||
+		del.Before == nil || del.Before.DeletedAt != nil || del.After == nil || del.After.DeletedAt == nil {
+		t.Errorf("delete entry = %+v", del)
+	}
+	// The key itself never reaches the log.
+	if actor == "" || actor == "secret" {
+		t.Errorf("actor = %q, want a hash of the key", actor)
+	}
+
+	// The file outlives the server.
+	reopened, err := newFileAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if all, err := reopened.List(); err != nil || len(all) != 3 {
+		t.Errorf("reopened log has %d entries, %v; want 3", len(all), err)
+	}
+}
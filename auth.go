@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// authMiddleware requires a valid X-API-Key header on requests that
+// modify data, leaving reads public. With no keys configured it lets
+// everything through.
+func authMiddleware(keys []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(keys) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !isWrite(r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			key := r.Header.Get("X-API-Key")
+			if key == "" {
+				writeJSONError(w, http.StatusUnauthorized, "Missing API key")
+				return
+			}
+			if !validAPIKey(keys, key) {
+				writeJSONError(w, http.StatusForbidden, "Invalid API key")
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(withActor(r.Context(), key)))
+		})
+	}
+}
+
+// isWrite reports whether method is one the API uses to modify data.
+func isWrite(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// readOnlyRetryAfter is the Retry-After, in seconds, sent with writes
+// refused in read-only mode.
+const readOnlyRetryAfter = "300"
+
+// readOnlyMiddleware refuses every write with a 503 while enabled, so the
+// data can't change during maintenance but reads carry on as usual.
+func readOnlyMiddleware(enabled bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !enabled {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !isWrite(r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("Retry-After", readOnlyRetryAfter)
+			writeJSONError(w, http.StatusServiceUnavailable, "The server is in read-only mode for maintenance")
+		})
+	}
+}
+
+func validAPIKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			return true
+		}
+	}
+	return false
+}
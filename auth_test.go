@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestAPIKeys(t *testing.T) {
+	cfg := testConfig()
+	cfg.APIKeys = []string{"k1", "k2"}
+	ts := newTestServer(t, cfg)
+
+	tests := []struct {
+		name    string
+		headers []string
+		status  int
+	}{
+		{"missing key", nil, http.StatusUnauthorized},
+		{"wrong key", []string{"X-API-Key", "k3"}, http.StatusForbidden},
+		{"valid key", []string{"X-API-Key", "k2"}, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		resp := ts.do("POST", "/v1/movies", movieJSON("Heat"), tt.headers...)
+		expectStatus(t, resp, tt.status)
+		if tt.status != http.StatusCreated {
+			if got := decodeBody[errorBody](t, resp).Error.Code; got != errorCode(tt.status) {
+				t.Errorf("%s: error code = %q", tt.name, got)
+			}
+		}
+	}
+	for _, method := range []string{"PUT", "PATCH", "DELETE"} {
+		expectStatus(t, ts.do(method, "/v1/movies/1", "{}"), http.StatusUnauthorized)
+	}
+	// Reads stay public.
+	expectStatus(t, ts.do("GET", "/v1/movies", ""), http.StatusOK)
+}
+
+func TestReadOnly(t *testing.T) {
+	store := newMemoryStore(nil, 0)
+	movie, err := store.Create(context.Background(), testMovie("", "Heat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := testConfig()
+	cfg.ReadOnly = true
+	ts := startServer(t, store, cfg)
+
+	for _, req := range []struct{ method, path, body string }{
+		{"POST", "/v1/movies", movieJSON("Ronin")},
+		{"PUT", "/v1/movies/" + movie.ID, movieJSON("Ronin")},
+		{"PATCH", "/v1/movies/" + movie.ID, `{"title":"Ronin","version":1}`},
+		{"DELETE", "/v1/movies/" + movie.ID, ""},
+		{"POST", "/v1/movies/batch-delete", `{"ids":["` + movie.ID + `"]}`},
+		{"POST", "/v1/directors", `{"firstName":"Michael","lastName":"Mann"}`},
+	} {
+		resp := ts.do(req.method, req.path, req.body)
+		expectStatus(t, resp, http.StatusServiceUnavailable)
+		if got := resp.Header.Get("Retry-After"); got != readOnlyRetryAfter {
+			t.Errorf("%s %s: Retry-After = %q", req.method, req.path, got)
+		}
+		if got := decodeBody[errorBody](t, resp).Error; got.Code != errorCode(http.StatusServiceUnavailable) || got.Message == "" {
+			t.Errorf("%s %s: error = %+v", req.method, req.path, got)
+		}
+	}
+
+	resp := ts.do("GET", "/v1/movies/"+movie.ID, "")
+	expectStatus(t, resp, http.StatusOK)
+	if got := decodeBody[Movie](t, resp); got.Title != "Heat" || got.Version != movie.Version {
+		t.Errorf("movie after blocked writes = %+v", got)
+	}
+	for _, path := range []string{"/v1/movies", "/v1/movies/count", "/v1/directors", "/healthz"} {
+		expectStatus(t, ts.do("GET", path, ""), http.StatusOK)
+	}
+	expectStatus(t, ts.do("HEAD", "/v1/movies", ""), http.StatusOK)
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultPort = "8000"
+
+// Config holds every setting the server reads from the environment.
+type Config struct {
+	Port              string
+	SeedData          bool
+	DBPath            string
+	DatabaseURL       string
+	RateLimitRPS      float64
+	RateLimitBurst    int
+	APIKeys           []string
+	AllowedOrigins    []string
+	MaxBodyBytes      int64
+	IdempotencyTTL    time.Duration
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	DefaultPageSize   int
+	MaxPageSize       int
+	// DuplicateTitlePolicy is one of the duplicateTitle* policies.
+	DuplicateTitlePolicy string
+	LogLevel             slog.Level
+	// LogFormat is logFormatJSON or logFormatText.
+	LogFormat string
+	// TLSCertFile and TLSKeyFile are either both set, to serve HTTPS, or
+	// both empty.
+	TLSCertFile string
+	TLSKeyFile  string
+	// BasePath is the prefix every route is mounted under, such as "/api",
+	// or empty to serve from the root. It never ends in a slash.
+	BasePath string
+	// AuditLogPath is the file the audit log is appended to, or empty to
+	// keep it in memory.
+	AuditLogPath string
+	// IDGenerator is the idGenerator scheme new movies and directors are
+	// named with.
+	IDGenerator string
+	// WebhookURLs are notified of every change to a movie.
+	WebhookURLs        []string
+	WebhookTimeout     time.Duration
+	WebhookMaxAttempts int
+	// ReadOnly refuses every write with a 503, for maintenance.
+	ReadOnly bool
+	// CORSMaxAge is how long browsers may cache a preflight answer, or
+	// zero to leave it to them.
+	CORSMaxAge time.Duration
+	// CORSAllowCredentials lets browsers send cookies with cross-origin
+	// requests. It requires AllowedOrigins to name every origin.
+	CORSAllowCredentials bool
+	// DeleteResponse is one of the deleteResponse* choices.
+	DeleteResponse string
+	// OTLPEndpoint is where spans are exported to over OTLP/HTTP, or
+	// empty to turn tracing off.
+	OTLPEndpoint string
+	// MaxMovies caps how many movies the in-memory store holds, or is 0
+	// for no cap. Database stores don't support it.
+	MaxMovies int
+}
+
+// defaultConfig is the configuration used for anything the environment
+// leaves unset.
+func defaultConfig() Config {
+	return Config{
+		Port:              defaultPort,
+		RateLimitRPS:      10,
+		RateLimitBurst:    20,
+		AllowedOrigins:    []string{"*"},
+		MaxBodyBytes:      defaultMaxBodyBytes,
+		IdempotencyTTL:    defaultIdempotencyTTL,
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       15 * time.Second,
+		WriteTimeout:      15 * time.Second,
+		IdleTimeout:       60 * time.Second,
+		DefaultPageSize:   defaultLimit,
+		MaxPageSize:       maxLimit,
+
+		DuplicateTitlePolicy: duplicateTitleAllow,
+		LogLevel:             slog.LevelInfo,
+		LogFormat:            logFormatJSON,
+		IDGenerator:          idGeneratorUUID,
+		WebhookTimeout:       5 * time.Second,
+		WebhookMaxAttempts:   3,
+		DeleteResponse:       deleteResponseMovie,
+	}
+}
+
+// LoadConfig reads the configuration from the environment once, applying
+// defaults. Every invalid variable is reported in the returned error
+// rather than only the first.
+func LoadConfig() (Config, error) {
+	cfg := defaultConfig()
+	var errs []error
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	var err error
+	cfg.Port, err = resolvePort()
+	check(err)
+	cfg.SeedData, err = envBool("SEED_DATA", cfg.SeedData)
+	check(err)
+	cfg.ReadOnly, err = envBool("READ_ONLY", cfg.ReadOnly)
+	check(err)
+	cfg.DBPath = os.Getenv("MOVIES_DB_PATH")
+	cfg.DatabaseURL = os.Getenv("DATABASE_URL")
+	if cfg.DBPath != "" && cfg.DatabaseURL != "" {
+		check(errors.New("set only one of MOVIES_DB_PATH and DATABASE_URL"))
+	}
+	cfg.MaxMovies, err = envInt("MAX_MOVIES", cfg.MaxMovies)
+	check(err)
+	if cfg.MaxMovies > 0 && (cfg.DBPath != "" || cfg.DatabaseURL != "") {
+		check(errors.New("MAX_MOVIES only applies to the in-memory store"))
+	}
+	cfg.RateLimitRPS, err = envFloat("RATE_LIMIT_RPS", cfg.RateLimitRPS)
+	check(err)
+	cfg.RateLimitBurst, err = envInt("RATE_LIMIT_BURST", cfg.RateLimitBurst)
+	check(err)
+	cfg.APIKeys = envList("API_KEYS")
+	if origins := envList("CORS_ALLOWED_ORIGINS"); len(origins) > 0 {
+		cfg.AllowedOrigins = origins
+	}
+	cfg.CORSMaxAge, err = envDuration("CORS_MAX_AGE", cfg.CORSMaxAge)
+	check(err)
+	cfg.CORSAllowCredentials, err = envBool("CORS_ALLOW_CREDENTIALS", cfg.CORSAllowCredentials)
+	check(err)
+	if cfg.CORSAllowCredentials && slices.Contains(cfg.AllowedOrigins, "*") {
+		check(errors.New("CORS_ALLOW_CREDENTIALS requires CORS_ALLOWED_ORIGINS to list origins instead of *"))
+	}
+	maxBody, err := envInt("MAX_BODY_BYTES", int(cfg.MaxBodyBytes))
+	check(err)
+	cfg.MaxBodyBytes = int64(maxBody)
+	cfg.IdempotencyTTL, err = envDuration("IDEMPOTENCY_TTL", cfg.IdempotencyTTL)
+	check(err)
+	cfg.ReadHeaderTimeout, err = envDuration("READ_HEADER_TIMEOUT", cfg.ReadHeaderTimeout)
+	check(err)
+	cfg.ReadTimeout, err = envDuration("READ_TIMEOUT", cfg.ReadTimeout)
+	check(err)
+	cfg.WriteTimeout, err = envDuration("WRITE_TIMEOUT", cfg.WriteTimeout)
+	check(err)
+	cfg.IdleTimeout, err = envDuration("IDLE_TIMEOUT", cfg.IdleTimeout)
+	check(err)
+	cfg.DefaultPageSize, err = envInt("DEFAULT_PAGE_SIZE", cfg.DefaultPageSize)
+	check(err)
+	defaultErr := err
+	cfg.MaxPageSize, err = envInt("MAX_PAGE_SIZE", cfg.MaxPageSize)
+	check(err)
+	// The sizes are only compared once both parsed; a size that didn't is
+	// reported on its own.
+	if defaultErr == nil && err == nil && cfg.MaxPageSize < cfg.DefaultPageSize {
+		check(fmt.Errorf("MAX_PAGE_SIZE (%d) must not be less than DEFAULT_PAGE_SIZE (%d)", cfg.MaxPageSize, cfg.DefaultPageSize))
+	}
+	cfg.DuplicateTitlePolicy, err = envChoice("DUPLICATE_TITLE_POLICY", cfg.DuplicateTitlePolicy,
+		duplicateTitleAllow, duplicateTitleWarn, duplicateTitleReject)
+	check(err)
+	cfg.DeleteResponse, err = envChoice("DELETE_RESPONSE", cfg.DeleteResponse, deleteResponseMovie, deleteResponseNone)
+	check(err)
+	level, err := envChoice("LOG_LEVEL", "", "debug", "info", "warn", "error")
+	check(err)
+	if level != "" {
+		check(cfg.LogLevel.UnmarshalText([]byte(level)))
+	}
+	cfg.LogFormat, err = envChoice("LOG_FORMAT", cfg.LogFormat, logFormatJSON, logFormatText)
+	check(err)
+	cfg.TLSCertFile = os.Getenv("TLS_CERT_FILE")
+	cfg.TLSKeyFile = os.Getenv("TLS_KEY_FILE")
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		check(errors.New("set both TLS_CERT_FILE and TLS_KEY_FILE, or neither"))
+	}
+	check(checkFile("TLS_CERT_FILE", cfg.TLSCertFile))
+	check(checkFile("TLS_KEY_FILE", cfg.TLSKeyFile))
+	cfg.BasePath, err = envBasePath("BASE_PATH")
+	check(err)
+	cfg.AuditLogPath = os.Getenv("AUDIT_LOG_PATH")
+	cfg.OTLPEndpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	cfg.IDGenerator, err = envChoice("ID_GENERATOR", cfg.IDGenerator, idGeneratorUUID, idGeneratorSequential)
+	check(err)
+	cfg.WebhookURLs, err = envURLs("WEBHOOK_URLS")
+	check(err)
+	cfg.WebhookTimeout, err = envDuration("WEBHOOK_TIMEOUT", cfg.WebhookTimeout)
+	check(err)
+	cfg.WebhookMaxAttempts, err = envInt("WEBHOOK_MAX_ATTEMPTS", cfg.WebhookMaxAttempts)
+	check(err)
+	if err := errors.Join(errs...); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// LogValue summarizes c for the "server started" log line. Secrets stay
+// out of it: API keys only show as a count and the database only as the
+// kind of store.
+func (c Config) LogValue() slog.Value {
+	store := "memory"
+	switch {
+	case c.DatabaseURL != "":
+		store = "postgres"
+	case c.DBPath != "":
+		store = "sqlite"
+	}
+	return slog.GroupValue(
+		slog.String("store", store),
+		slog.Int("max_movies", c.MaxMovies),
+		slog.Bool("tls", c.TLSCertFile != ""),
+		slog.String("base_path", c.BasePath),
+		slog.Bool("read_only", c.ReadOnly),
+		slog.Int("api_keys", len(c.APIKeys)),
+		slog.Float64("rate_limit_rps", c.RateLimitRPS),
+		slog.Int("rate_limit_burst", c.RateLimitBurst),
+		slog.String("log_level", c.LogLevel.String()),
+		slog.String("id_generator", c.IDGenerator),
+		slog.Int("webhooks", len(c.WebhookURLs)),
+		slog.Bool("tracing", c.OTLPEndpoint != ""),
+	)
+}
+
+// resolvePort returns the port to listen on from PORT, defaulting to 8000.
+func resolvePort() (string, error) {
+	raw := os.Getenv("PORT")
+	if raw == "" {
+		return defaultPort, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("PORT must be a number between 1 and 65535, got %q", raw)
+	}
+	return raw, nil
+}
+
+// checkFile makes sure the file named by the environment variable name
+// exists, if one was named.
+func checkFile(name, path string) error {
+	if path == "" {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s: %s is a directory", name, path)
+	}
+	return nil
+}
+
+// envBasePath reads a URL path prefix such as /api from the environment
+// variable name, dropping any trailing slash so "/" means the root.
+func envBasePath(name string) (string, error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return "", nil
+	}
+	if !strings.HasPrefix(raw, "/") || strings.ContainsAny(raw, "?#{}") {
+		return "", fmt.Errorf("%s must be a path starting with /, got %q", name, raw)
+	}
+	return strings.TrimRight(raw, "/"), nil
+}
+
+// envInt reads a positive integer from the environment variable name,
+// returning def when it's unset.
+func envInt(name string, def int) (int, error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%s must be a positive integer, got %q", name, raw)
+	}
+	return n, nil
+}
+
+// envFloat reads a positive number from the environment variable name,
+// returning def when it's unset.
+func envFloat(name string, def float64) (float64, error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(raw, 64)
+	if err != nil || f <= 0 {
+		return 0, fmt.Errorf("%s must be a positive number, got %q", name, raw)
+	}
+	return f, nil
+}
+
+// envList reads a comma-separated list from the environment variable
+// name, dropping empty entries.
+func envList(name string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(name), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// envURLs reads a comma-separated list of http or https URLs from the
+// environment variable name.
+func envURLs(name string) ([]string, error) {
+	urls := envList(name)
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("%s must list http or https URLs, got %q", name, raw)
+		}
+	}
+	return urls, nil
+}
+
+// envDuration reads a positive duration such as "15s" from the
+// environment variable name, returning def when it's unset.
+func envDuration(name string, def time.Duration) (time.Duration, error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%s must be a positive duration such as 15s, got %q", name, raw)
+	}
+	return d, nil
+}
+
+// envBool reads a boolean such as "true" or "0" from the environment
+// variable name, returning def when it's unset.
+func envBool(name string, def bool) (bool, error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("%s must be true or false, got %q", name, raw)
+	}
+	return b, nil
+}
+
+// envChoice reads one of choices from the environment variable name,
+// returning def when it's unset.
+func envChoice(name, def string, choices ...string) (string, error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def, nil
+	}
+	for _, c := range choices {
+		if raw == c {
+			return raw, nil
+		}
+	}
+	return "", fmt.Errorf("%s must be one of %s, got %q", name, strings.Join(choices, ", "), raw)
+}
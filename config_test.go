@@ -0,0 +1,146 @@
+package main
+
+import (
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolvePort(t *testing.T) {
+	tests := []struct {
+		env  string
+		want string
+		ok   bool
+	}{
+		{"", defaultPort, true},
+		{"9090", "9090", true},
+		{"65535", "65535", true},
+		{"0", "", false},
+		{"65536", "", false},
+		{"-1", "", false},
+		{"http", "", false},
+		{":8000", "", false},
+	}
+	for _, tt := range tests {
+		t.Setenv("PORT", tt.env)
+		got, err := resolvePort()
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("PORT=%q: got %q, %v", tt.env, got, err)
+		}
+	}
+}
+
+func TestLoadConfigTimeouts(t *testing.T) {
+	t.Setenv("READ_TIMEOUT", "2s")
+	t.Setenv("IDLE_TIMEOUT", "90s")
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	def := defaultConfig()
+	if cfg.ReadTimeout != 2*time.Second || cfg.IdleTimeout != 90*time.Second || cfg.WriteTimeout != def.WriteTimeout || cfg.ReadHeaderTimeout != def.ReadHeaderTimeout {
+		t.Errorf("timeouts = %v, %v, %v, %v", cfg.ReadHeaderTimeout, cfg.ReadTimeout, cfg.WriteTimeout, cfg.IdleTimeout)
+	}
+	t.Setenv("WRITE_TIMEOUT", "soon")
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "WRITE_TIMEOUT") {
+		t.Errorf("invalid WRITE_TIMEOUT: got %v", err)
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg, defaultConfig()) {
+		t.Errorf("LoadConfig with nothing set = %+v, want %+v", cfg, defaultConfig())
+	}
+
+	t.Setenv("SEED_DATA", "1")
+	t.Setenv("RATE_LIMIT_RPS", "2.5")
+	t.Setenv("API_KEYS", " a, ,b ")
+	t.Setenv("MOVIES_DB_PATH", "movies.db")
+	t.Setenv("LOG_LEVEL", "debug")
+	cfg, err = LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.SeedData || cfg.RateLimitRPS != 2.5 || strings.Join(cfg.APIKeys, "|") != "a|b" || cfg.DBPath != "movies.db" || cfg.LogLevel != slog.LevelDebug {
+		t.Errorf("parsed config = %+v", cfg)
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	tests := []struct{ name, value string }{
+		{"SEED_DATA", "yes please"},
+		{"RATE_LIMIT_RPS", "0"},
+		{"RATE_LIMIT_BURST", "-3"},
+		{"MAX_BODY_BYTES", "1MB"},
+		{"DEFAULT_PAGE_SIZE", "many"},
+		{"IDEMPOTENCY_TTL", "a day"},
+		{"DUPLICATE_TITLE_POLICY", "ignore"},
+		{"LOG_LEVEL", "verbose"},
+		{"LOG_FORMAT", "xml"},
+		{"TLS_CERT_FILE", "cert.pem"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(tt.name, tt.value)
+			if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), tt.name) {
+				t.Errorf("%s=%q: got %v, want an error naming it", tt.name, tt.value, err)
+			}
+		})
+	}
+
+	t.Run("every error", func(t *testing.T) {
+		t.Setenv("PORT", "http")
+		t.Setenv("MAX_PAGE_SIZE", "5")
+		t.Setenv("MOVIES_DB_PATH", "movies.db")
+		t.Setenv("DATABASE_URL", "postgres://localhost/movies")
+		_, err := LoadConfig()
+		for _, want := range []string{"PORT", "MAX_PAGE_SIZE", "MOVIES_DB_PATH and DATABASE_URL"} {
+			if err == nil || !strings.Contains(err.Error(), want) {
+				t.Errorf("error %v does not mention %s", err, want)
+			}
+		}
+	})
+}
+
+func TestLoadConfigCORSCredentials(t *testing.T) {
+	t.Setenv("CORS_ALLOW_CREDENTIALS", "true")
+	t.Setenv("CORS_MAX_AGE", "10m")
+	// The default allowlist is *, which credentials mode refuses.
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "CORS_ALLOW_CREDENTIALS") {
+		t.Errorf("credentials with the default origins: got %v", err)
+	}
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://app.example,*")
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "CORS_ALLOW_CREDENTIALS") {
+		t.Errorf("credentials with * listed: got %v", err)
+	}
+
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://app.example")
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.CORSAllowCredentials || cfg.CORSMaxAge != 10*time.Minute {
+		t.Errorf("credentials %v, max age %v", cfg.CORSAllowCredentials, cfg.CORSMaxAge)
+	}
+}
+
+func TestLoadConfigMaxMovies(t *testing.T) {
+	cfg, err := LoadConfig()
+	if err != nil || cfg.MaxMovies != 0 {
+		t.Fatalf("unset MAX_MOVIES: got %d, %v, want unlimited", cfg.MaxMovies, err)
+	}
+	t.Setenv("MAX_MOVIES", "2")
+	if cfg, err := LoadConfig(); err != nil || cfg.MaxMovies != 2 {
+		t.Errorf("MAX_MOVIES=2: got %d, %v", cfg.MaxMovies, err)
+	}
+	t.Setenv("MOVIES_DB_PATH", t.TempDir()+"/movies.db")
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "MAX_MOVIES") {
+		t.Errorf("MAX_MOVIES with SQLite: got %v, want an error naming MAX_MOVIES", err)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/csv"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// negotiate picks the first of offers the Accept header allows, or ""
+// if it allows none of them. A missing Accept header allows anything.
+func negotiate(accept string, offers ...string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		for _, offer := range offers {
+			if mediaType == offer || mediaType == "*/*" ||
+				(strings.HasSuffix(mediaType, "/*") && strings.HasPrefix(offer, strings.TrimSuffix(mediaType, "*"))) {
+				return offer
+			}
+		}
+	}
+	return ""
+}
+
+// writeCSV writes movies as CSV with a header row. A movie's genres share
+// one column, separated by semicolons.
+func writeCSV(w http.ResponseWriter, movies []Movie) error {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "isbn", "title", "director_first_name", "director_last_name", "genres", "year"})
+	for _, m := range movies {
+		var first, last string
+		if m.Director != nil {
+			first, last = m.Director.FirstName, m.Director.LastName
+		}
+		var year string
+		if m.Year != 0 {
+			year = strconv.Itoa(m.Year)
+		}
+		cw.Write([]string{m.ID, m.ISBN, m.Title, first, last, strings.Join(m.Genres, ";"), year})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestListCSV(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	heat := ts.create(`{"isbn":"0306406152","title":"Heat, the movie","director":{"firstName":"Michael","lastName":"Mann"},"genres":["crime","drama"],"year":1995}`)
+	ronin := ts.create(movieJSON("Ronin"))
+
+	resp := ts.do("GET", "/v1/movies", "", "Accept", "text/csv")
+	expectStatus(t, resp, http.StatusOK)
+	if got := resp.Header.Get("Content-Type"); got != "text/csv; charset=utf-8" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if got := resp.Header.Get("X-Total-Count"); got != "2" {
+		t.Errorf("X-Total-Count = %q", got)
+	}
+	want := "id,isbn,title,director_first_name,director_last_name,genres,year\n" +
+		heat.ID + `,0306406152,"Heat, the movie",Michael,Mann,crime;drama,1995` + "\n" +
+		ronin.ID + ",0306406152,Ronin,Christopher,Nolan,,\n"
+	if got := readBody(t, resp); got != want {
+		t.Errorf("CSV =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestListNegotiation(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	tests := []struct {
+		accept string
+		status int
+		ctype  string
+	}{
+		{"", http.StatusOK, "application/json"},
+		{"application/json", http.StatusOK, "application/json"},
+		{"*/*", http.StatusOK, "application/json"},
+		{"text/*", http.StatusOK, "text/csv; charset=utf-8"},
+		{"application/xml", http.StatusNotAcceptable, "application/json"},
+	}
+	for _, tt := range tests {
+		resp := ts.do("GET", "/v1/movies", "", "Accept", tt.accept)
+		expectStatus(t, resp, tt.status)
+		if got := resp.Header.Get("Content-Type"); got != tt.ctype {
+			t.Errorf("Accept %q: Content-Type = %q, want %q", tt.accept, got, tt.ctype)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// decodeError is a problem with a request body, carrying the status it
+// should be reported with.
+type decodeError struct {
+	status int
+	msg    string
+}
+
+func (e *decodeError) Error() string {
+	return e.msg
+}
+
+// schemaError reports a request body that doesn't satisfy its schema.
+type schemaError struct {
+	violations []FieldError
+}
+
+func (e *schemaError) Error() string {
+	return "request body does not match the schema"
+}
+
+// decodeJSON decodes the request body into v, rejecting fields v doesn't
+// declare so typos don't silently turn into empty values, and an empty or
+// null body since neither describes anything.
+func decodeJSON(r *http.Request, v any) error {
+	return decodeJSONWithSchema(r, nil, v)
+}
+
+// decodeJSONWithSchema is decodeJSON that also checks the body against
+// schema, when it isn't nil, so type mismatches are reported per field
+// rather than as a single decoding error.
+func decodeJSONWithSchema(r *http.Request, schema *jsonschema.Schema, v any) error {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		return &decodeError{status: http.StatusUnsupportedMediaType, msg: "Content-Type must be application/json"}
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return &decodeError{status: http.StatusRequestEntityTooLarge, msg: fmt.Sprintf("request body must not exceed %d bytes", tooLarge.Limit)}
+		}
+		return &decodeError{status: http.StatusBadRequest, msg: err.Error()}
+	}
+	switch trimmed := bytes.TrimSpace(body); {
+	case len(trimmed) == 0:
+		return &decodeError{status: http.StatusBadRequest, msg: "request body is required"}
+	case bytes.Equal(trimmed, []byte("null")):
+		// null would decode into v as a no-op, leaving its zero value to
+		// be stored as if the client had sent it.
+		return &schemaError{violations: []FieldError{{Field: "body", Message: "request body must not be null"}}}
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	decodeErr := dec.Decode(v)
+	if decodeErr != nil && strings.HasPrefix(decodeErr.Error(), "json: unknown field ") {
+		return &decodeError{status: http.StatusBadRequest, msg: fmt.Sprintf("request body contains unknown field %s", strings.TrimPrefix(decodeErr.Error(), "json: unknown field "))}
+	}
+	if schema != nil {
+		var doc any
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		if err := dec.Decode(&doc); err != nil {
+			return &decodeError{status: http.StatusBadRequest, msg: describeJSONError(body, err)}
+		}
+		if err := schema.Validate(doc); err != nil {
+			return &schemaError{violations: schemaViolations(err)}
+		}
+	}
+	if decodeErr != nil {
+		return &decodeError{status: http.StatusBadRequest, msg: describeJSONError(body, decodeErr)}
+	}
+	return nil
+}
+
+// writeDecodeError reports an error returned by decodeJSON.
+func writeDecodeError(w http.ResponseWriter, err error) {
+	var de *decodeError
+	if errors.As(err, &de) {
+		writeJSONError(w, de.status, de.msg)
+		return
+	}
+	var se *schemaError
+	if errors.As(err, &se) {
+		writeFieldErrors(w, se.violations)
+		return
+	}
+	writeJSONError(w, http.StatusBadRequest, err.Error())
+}
+
+// describeJSONError turns an error from decoding body into a message that
+// says where the problem is, rather than encoding/json's terse wording.
+func describeJSONError(body []byte, err error) string {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		line, col := position(body, syntaxErr.Offset)
+		return fmt.Sprintf("malformed JSON at line %d, column %d (offset %d): %s", line, col, syntaxErr.Offset, strings.TrimPrefix(syntaxErr.Error(), "json: "))
+	case errors.As(err, &typeErr):
+		if typeErr.Field == "" {
+			return fmt.Sprintf("invalid value at offset %d: expected %s, got %s", typeErr.Offset, jsonKind(typeErr.Type), typeErr.Value)
+		}
+		return fmt.Sprintf("invalid value for field '%s' at offset %d: expected %s, got %s", typeErr.Field, typeErr.Offset, jsonKind(typeErr.Type), typeErr.Value)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return "malformed JSON: request body ends in the middle of a value"
+	}
+	return err.Error()
+}
+
+// position returns the 1-based line and column of the byte a
+// json.SyntaxError's Offset points just past, which is the one the decoder
+// choked on.
+func position(body []byte, offset int64) (line, col int) {
+	if offset > int64(len(body)) {
+		offset = int64(len(body))
+	}
+	if offset > 0 {
+		offset--
+	}
+	before := body[:offset]
+	line = bytes.Count(before, []byte("\n")) + 1
+	col = len(before) - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
+
+// jsonKind names the JSON type a value of t is decoded from.
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Struct, reflect.Map:
+		return "object"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	}
+	return t.String()
+}
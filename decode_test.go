@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestUnknownFieldRejected(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	id := ts.create(movieJSON("Heat")).ID
+	body := `{"isbn":"0306406152","titel":"Heat","director":{"firstName":"Michael","lastName":"Mann"}}`
+	for _, req := range []struct{ method, path string }{
+		{"POST", "/v1/movies"},
+		{"PUT", "/v1/movies/" + id},
+	} {
+		resp := ts.do(req.method, req.path, body)
+		expectStatus(t, resp, http.StatusBadRequest)
+		want := `request body contains unknown field "titel"`
+		if got := decodeBody[errorBody](t, resp).Error.Message; got != want {
+			t.Errorf("%s: message = %q, want %q", req.method, got, want)
+		}
+	}
+}
+
+func TestContentTypeEnforced(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	id := ts.create(movieJSON("Heat")).ID
+	for _, ct := range []string{"text/plain", "application/x-www-form-urlencoded", "application/jsonx"} {
+		for _, req := range []struct{ method, path string }{
+			{"POST", "/v1/movies"},
+			{"PUT", "/v1/movies/" + id},
+		} {
+			resp := ts.do(req.method, req.path, movieJSON("Ronin"), "Content-Type", ct)
+			expectStatus(t, resp, http.StatusUnsupportedMediaType)
+		}
+	}
+	// A charset parameter is fine.
+	resp := ts.do("POST", "/v1/movies", movieJSON("Ronin"), "Content-Type", "application/json; charset=utf-8")
+	expectStatus(t, resp, http.StatusCreated)
+}
+
+func TestBodyTooLarge(t *testing.T) {
+	cfg := testConfig()
+	cfg.MaxBodyBytes = 256
+	ts := newTestServer(t, cfg)
+	big := `{"isbn":"0306406152","title":"` + strings.Repeat("x", 300) + `","director":{"firstName":"Michael","lastName":"Mann"}}`
+	resp := ts.do("POST", "/v1/movies", big)
+	expectStatus(t, resp, http.StatusRequestEntityTooLarge)
+	if got := decodeBody[errorBody](t, resp).Error.Message; got != "request body must not exceed 256 bytes" {
+		t.Errorf("message = %q", got)
+	}
+	ts.create(movieJSON("Heat"))
+}
+
+func TestMalformedJSONMessages(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	id := ts.create(movieJSON("Heat")).ID
+	tests := []struct {
+		method, path, body string
+		want               string
+	}{
+		{"POST", "/v1/movies", "{\"isbn\":\"0306406152\",\n\"title\":\"Heat\",}",
+			"malformed JSON at line 2, column 16 (offset 38): invalid character '}' looking for beginning of object key string"},
+		{"POST", "/v1/movies", `{"isbn":"0306406152"`,
+			"malformed JSON: request body ends in the middle of a value"},
+		{"PATCH", "/v1/movies/" + id, `{"title":42,"version":1}`,
+			"invalid value for field 'title' at offset 11: expected string, got number"},
+		{"PATCH", "/v1/movies/" + id, `{"title":"Heat","version":"1"}`,
+			"invalid value for field 'version' at offset 29: expected number, got string"},
+		{"POST", "/v1/directors", `["Michael","Mann"]`,
+			"invalid value at offset 1: expected object, got array"},
+	}
+	for _, tt := range tests {
+		resp := ts.do(tt.method, tt.path, tt.body)
+		expectStatus(t, resp, http.StatusBadRequest)
+		if got := decodeBody[errorBody](t, resp).Error.Message; got != tt.want {
+			t.Errorf("%s %s %s:\nmessage %q\nwant    %q", tt.method, tt.path, tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestPosition(t *testing.T) {
+	body := []byte("{\n  \"a\": x\n}")
+	for _, tt := range []struct {
+		offset    int64
+		line, col int
+	}{
+		{1, 1, 1},
+		{10, 2, 8},
+		{100, 3, 1},
+	} {
+		if line, col := position(body, tt.offset); line != tt.line || col != tt.col {
+			t.Errorf("position(%d) = %d:%d, want %d:%d", tt.offset, line, col, tt.line, tt.col)
+		}
+	}
+}
+
+func TestEmptyAndNullBodies(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	id := ts.create(movieJSON("Heat")).ID
+	for _, req := range []struct{ method, path string }{
+		{"POST", "/v1/movies"},
+		{"PUT", "/v1/movies/" + id},
+		{"PATCH", "/v1/movies/" + id},
+		{"POST", "/v1/directors"},
+	} {
+		for _, body := range []string{"", " \n\t"} {
+			resp := ts.do(req.method, req.path, body, "Content-Type", "application/json")
+			expectStatus(t, resp, http.StatusBadRequest)
+			if got := decodeBody[errorBody](t, resp).Error.Message; got != "request body is required" {
+				t.Errorf("%s %s with body %q: message = %q", req.method, req.path, body, got)
+			}
+		}
+		resp := ts.do(req.method, req.path, " null ")
+		expectStatus(t, resp, http.StatusUnprocessableEntity)
+		if errs := decodeBody[[]FieldError](t, resp); len(errs) != 1 || errs[0].Field != "body" {
+			t.Errorf("%s %s with null: errors %v, want one for body", req.method, req.path, errs)
+		}
+	}
+
+	// Nothing was stored or changed.
+	list := ts.list("")
+	if len(list.Data) != 1 || list.Data[0].Title != "Heat" || list.Data[0].Version != 1 {
+		t.Errorf("movies = %+v, want only the unchanged original", list.Data)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+)
+
+func (h *handler) getDirectors(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	directors, err := h.directors.GetAll(r.Context())
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	newJSONEncoder(w, r).Encode(directors)
+}
+
+// getMovieDirectors lists the directors the live movies currently
+// reference, for front-ends that fill filter dropdowns from it. Unlike
+// getDirectors it includes inline directors that were never created as a
+// resource.
+func (h *handler) getMovieDirectors(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	movies, err := h.liveMovies(r.Context())
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	movies, err = h.withDirectors(r.Context(), movies)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	newJSONEncoder(w, r).Encode(distinctDirectors(movies))
+}
+
+// distinctDirectors returns each director named by movies once, sorted by
+// last name and then first name. Directors are the same when both names
+// match; the first one seen wins, so a linked director keeps its ID.
+func distinctDirectors(movies []Movie) []Director {
+	type name struct{ first, last string }
+	seen := make(map[name]bool)
+	directors := []Director{}
+	for _, movie := range movies {
+		if movie.Director == nil {
+			continue
+		}
+		key := name{movie.Director.FirstName, movie.Director.LastName}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		directors = append(directors, *movie.Director)
+	}
+	sort.SliceStable(directors, func(i, j int) bool {
+		return sortsBefore(directors[i], directors[j])
+	})
+	return directors
+}
+
+func (h *handler) getDirector(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	director, err := h.directors.GetByID(r.Context(), mux.Vars(r)["id"])
+	if errors.Is(err, ErrNotFound) {
+		writeJSONError(w, http.StatusNotFound, "Director not found")
+		return
+	}
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	newJSONEncoder(w, r).Encode(director)
+}
+
+func (h *handler) createDirector(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var director Director
+	if err := decodeJSON(r, &director); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if errs := validateDirector(director); errs != nil {
+		writeFieldErrors(w, errs)
+		return
+	}
+	director, err := h.directors.Create(r.Context(), director)
+	if errors.Is(err, ErrDuplicateID) {
+		writeJSONError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", h.basePath+"/v1/directors/"+director.ID)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(director)
+}
+
+func (h *handler) updateDirector(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var director Director
+	if err := decodeJSON(r, &director); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if errs := validateDirector(director); errs != nil {
+		writeFieldErrors(w, errs)
+		return
+	}
+	director, err := h.directors.Update(r.Context(), mux.Vars(r)["id"], director)
+	if errors.Is(err, ErrNotFound) {
+		writeJSONError(w, http.StatusNotFound, "Director not found")
+		return
+	}
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	// Movies linked to the director embed it.
+	h.touch()
+	json.NewEncoder(w).Encode(director)
+}
+
+func (h *handler) deleteDirector(w http.ResponseWriter, r *http.Request) {
+	err := h.directors.Delete(r.Context(), mux.Vars(r)["id"])
+	if errors.Is(err, ErrNotFound) {
+		writeJSONError(w, http.StatusNotFound, "Director not found")
+		return
+	}
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	h.touch()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// linkDirector fills in movie.Director from the director movie.DirectorID
+// refers to, reporting a field error when there is no such director. err
+// is set only when the director store itself failed.
+func (h *handler) linkDirector(ctx context.Context, movie *Movie) ([]FieldError, error) {
+	if movie.DirectorID == "" {
+		return nil, nil
+	}
+	director, err := h.directors.GetByID(ctx, movie.DirectorID)
+	if errors.Is(err, ErrNotFound) {
+		return []FieldError{{Field: "directorId", Message: "director not found"}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	movie.Director = &director
+	return nil, nil
+}
+
+// withDirector refreshes the embedded director of a movie that links to a
+// director resource, so reads see its current details. Movies whose
+// director has since been deleted keep the copy stored with them.
+func (h *handler) withDirector(ctx context.Context, movie Movie) (Movie, error) {
+	if movie.DirectorID == "" {
+		return movie, nil
+	}
+	director, err := h.directors.GetByID(ctx, movie.DirectorID)
+	if errors.Is(err, ErrNotFound) {
+		return movie, nil
+	}
+	if err != nil {
+		return Movie{}, err
+	}
+	movie.Director = &director
+	return movie, nil
+}
+
+func (h *handler) withDirectors(ctx context.Context, movies []Movie) ([]Movie, error) {
+	for i := range movies {
+		movie, err := h.withDirector(ctx, movies[i])
+		if err != nil {
+			return nil, err
+		}
+		movies[i] = movie
+	}
+	return movies, nil
+}
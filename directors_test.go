@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// createDirector POSTs a director to /v1/directors and returns it.
+func (ts *testServer) createDirector(first, last string) Director {
+	ts.t.Helper()
+	resp := ts.do("POST", "/v1/directors", fmt.Sprintf(`{"firstName":%q,"lastName":%q}`, first, last))
+	expectStatus(ts.t, resp, http.StatusCreated)
+	return decodeBody[Director](ts.t, resp)
+}
+
+func TestDirectorCRUD(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	director := ts.createDirector("Michael", "Mann")
+	if director.ID == "" {
+		t.Fatal("created director has no ID")
+	}
+
+	resp := ts.do("GET", "/v1/directors/"+director.ID, "")
+	expectStatus(t, resp, http.StatusOK)
+	if got := decodeBody[Director](t, resp); got != director {
+		t.Errorf("GET = %+v, want %+v", got, director)
+	}
+	resp = ts.do("PUT", "/v1/directors/"+director.ID, `{"firstName":"Michael K.","lastName":"Mann"}`)
+	expectStatus(t, resp, http.StatusOK)
+	resp = ts.do("GET", "/v1/directors", "")
+	if got := decodeBody[[]Director](t, resp); len(got) != 1 || got[0].FirstName != "Michael K." {
+		t.Errorf("list after update = %+v", got)
+	}
+
+	expectStatus(t, ts.do("POST", "/v1/directors", `{"firstName":"Michael"}`), http.StatusUnprocessableEntity)
+	expectStatus(t, ts.do("DELETE", "/v1/directors/"+director.ID, ""), http.StatusNoContent)
+	expectStatus(t, ts.do("GET", "/v1/directors/"+director.ID, ""), http.StatusNotFound)
+	expectStatus(t, ts.do("PUT", "/v1/directors/"+director.ID, `{"firstName":"A","lastName":"B"}`), http.StatusNotFound)
+}
+
+func TestMovieLinksDirector(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	director := ts.createDirector("Michael", "Mann")
+
+	movie := ts.create(fmt.Sprintf(`{"isbn":"0306406152","title":"Heat","directorId":%q}`, director.ID))
+	if movie.DirectorID != director.ID || movie.Director == nil || *movie.Director != director {
+		t.Fatalf("created movie = %+v, want director %+v embedded", movie, director)
+	}
+
+	// Reads embed the director as it is now.
+	ts.do("PUT", "/v1/directors/"+director.ID, `{"firstName":"Michael K.","lastName":"Mann"}`)
+	resp := ts.do("GET", "/v1/movies/"+movie.ID, "")
+	if got := decodeBody[Movie](t, resp).Director; got == nil || got.FirstName != "Michael K." {
+		t.Errorf("embedded director after rename = %+v", got)
+	}
+
+	resp = ts.do("POST", "/v1/movies", `{"isbn":"0306406152","title":"Thief","directorId":"missing"}`)
+	expectStatus(t, resp, http.StatusUnprocessableEntity)
+	if errs := decodeBody[[]FieldError](t, resp); len(errs) != 1 || errs[0].Field != "directorId" {
+		t.Errorf("unknown director: errors %v", errs)
+	}
+}
+
+func TestDistinctDirectors(t *testing.T) {
+	d := func(first, last string) *Director { return &Director{FirstName: first, LastName: last} }
+	movies := []Movie{
+		{Title: "Heat", Director: d("Michael", "Mann")},
+		{Title: "Ronin", Director: d("John", "Frankenheimer")},
+		{Title: "Thief", Director: d("Michael", "Mann")},
+		{Title: "Legacy"},
+		{Title: "Manhunt", Director: d("Delbert", "Mann")},
+		{Title: "Halloween", Director: d("John", "Carpenter")},
+	}
+	got := fmt.Sprint(distinctDirectors(movies))
+	want := "[{ John Carpenter} { John Frankenheimer} { Delbert Mann} { Michael Mann}]"
+	if got != want {
+		t.Errorf("distinctDirectors = %s, want %s", got, want)
+	}
+	if got := distinctDirectors(nil); got == nil || len(got) != 0 {
+		t.Errorf("distinctDirectors(nil) = %#v, want an empty slice", got)
+	}
+}
+
+func TestMovieDirectorsRoute(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	ts.create(`{"isbn":"0306406152","title":"Heat","director":{"firstName":"Michael","lastName":"Mann"}}`)
+	ts.create(`{"isbn":"0306406152","title":"Thief","director":{"firstName":"Michael","lastName":"Mann"}}`)
+	gone := ts.create(`{"isbn":"0306406152","title":"Ronin","director":{"firstName":"John","lastName":"Frankenheimer"}}`)
+	ts.do("DELETE", "/v1/movies/"+gone.ID, "")
+
+	resp := ts.do("GET", "/v1/movies/directors", "")
+	expectStatus(t, resp, http.StatusOK)
+	// A director only on a deleted movie is left out.
+	if got := decodeBody[[]Director](t, resp); len(got) != 1 || got[0].LastName != "Mann" {
+		t.Errorf("directors = %+v, want only Michael Mann", got)
+	}
+}
+
+func TestUpdateMovieDirector(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	linked := ts.createDirector("Michael", "Mann")
+	movie := ts.create(fmt.Sprintf(`{"isbn":"0306406152","title":"Heat","directorId":%q,"year":1995}`, linked.ID))
+	path := "/v1/movies/" + movie.ID + "/director"
+
+	resp := ts.do("PUT", path, `{"firstName":"John","lastName":"Frankenheimer"}`)
+	expectStatus(t, resp, http.StatusOK)
+	got := decodeBody[Movie](t, resp)
+	if got.Director == nil || got.Director.LastName != "Frankenheimer" || got.DirectorID != "" {
+		t.Errorf("director after PUT = %+v, id %q; want Frankenheimer, unlinked", got.Director, got.DirectorID)
+	}
+	if got.Title != "Heat" || got.Year != 1995 || got.Version != 2 {
+		t.Errorf("other fields after PUT = %+v, want them untouched at version 2", got)
+	}
+
+	resp = ts.do("PUT", path, `{"firstName":"John"}`)
+	expectStatus(t, resp, http.StatusUnprocessableEntity)
+	if errs := decodeBody[[]FieldError](t, resp); len(errs) != 1 || errs[0].Field != "lastName" {
+		t.Errorf("errors = %v, want one for lastName", errs)
+	}
+	expectStatus(t, ts.do("PUT", "/v1/movies/missing/director", `{"firstName":"John","lastName":"Woo"}`), http.StatusNotFound)
+}
+
+// failingDirectorStore is a director store whose calls all fail with err.
+type failingDirectorStore struct {
+	DirectorStore
+	err error
+}
+
+func (s failingDirectorStore) GetAll(ctx context.Context) ([]Director, error) {
+	return nil, s.err
+}
+
+func (s failingDirectorStore) GetByID(ctx context.Context, id string) (Director, error) {
+	return Director{}, s.err
+}
+
+func (s failingDirectorStore) Update(ctx context.Context, id string, director Director) (Director, error) {
+	return Director{}, s.err
+}
+
+func (s failingDirectorStore) Delete(ctx context.Context, id string) error {
+	return s.err
+}
+
+func TestDirectorStoreErrors(t *testing.T) {
+	movies := newMemoryStore(nil, 0)
+	movie := testMovie("", "Heat")
+	movie.DirectorID = "mann"
+	movie, err := movies.Create(context.Background(), movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	directors := failingDirectorStore{DirectorStore: newMemoryDirectorStore(nil), err: errors.New("disk I/O error")}
+	ts := startServer(t, movies, testConfig(), WithDirectorStore(directors))
+
+	// None of these is an empty list, a missing director or a bad
+	// directorId.
+	for _, tt := range []struct{ method, path, body string }{
+		{"GET", "/v1/directors", ""},
+		{"GET", "/v1/directors/mann", ""},
+		{"PUT", "/v1/directors/mann", `{"firstName":"Michael","lastName":"Mann"}`},
+		{"DELETE", "/v1/directors/mann", ""},
+		{"GET", "/v1/movies/" + movie.ID, ""},
+		{"GET", "/v1/movies", ""},
+		{"POST", "/v1/movies", `{"isbn":"0306406152","title":"Ronin","directorId":"mann"}`},
+	} {
+		resp := ts.do(tt.method, tt.path, tt.body)
+		expectStatus(t, resp, http.StatusInternalServerError)
+		if got := decodeBody[errorBody](t, resp).Error.Message; got != "Internal server error" {
+			t.Errorf("%s %s: message = %q", tt.method, tt.path, got)
+		}
+	}
+}
+
+// testDirectorStoreNotFound checks that s reports a director that doesn't
+// exist as ErrNotFound, and one that does as found.
+func testDirectorStoreNotFound(t *testing.T, s DirectorStore) {
+	t.Helper()
+	ctx := context.Background()
+	created, err := s.Create(ctx, Director{FirstName: "Michael", LastName: "Mann"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.GetByID(ctx, created.ID); err != nil || got != created {
+		t.Errorf("GetByID(%s) = %+v, %v", created.ID, got, err)
+	}
+	if _, err := s.GetByID(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetByID: got %v, want ErrNotFound", err)
+	}
+	if _, err := s.Update(ctx, "missing", Director{FirstName: "A", LastName: "B"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Update: got %v, want ErrNotFound", err)
+	}
+	if err := s.Delete(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Delete: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestMemoryDirectorStoreNotFound(t *testing.T) {
+	testDirectorStoreNotFound(t, newMemoryDirectorStore(nil))
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// isDryRun reports whether a write request asked for ?dry_run=true, in
+// which case its handler validates everything as usual but answers with
+// the movie it would have stored instead of storing it.
+func isDryRun(r *http.Request) bool {
+	return queryBool(r.URL.Query(), "dry_run")
+}
+
+// writeDryRun answers a dry run with v, always as 200 since nothing was
+// created or changed.
+func writeDryRun(w http.ResponseWriter, v any) {
+	w.Header().Set("X-Dry-Run", "true")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(v)
+}
+
+// dryRunIDs names the movies a dry run would create. It is not the store's
+// generator, since a dry run must not use up IDs from a sequence.
+var dryRunIDs IDGenerator = uuidGenerator{}
+
+// existsIn returns an assignID callback reporting IDs the store already
+// has, so dry runs pick and check IDs the way Create would. A failed
+// lookup reports the ID as free; the store reads before it will have
+// failed the dry run already.
+func (h *handler) existsIn(ctx context.Context, taken map[string]bool) func(id string) bool {
+	return func(id string) bool {
+		_, ok, _ := h.store.GetByID(ctx, id)
+		return ok || taken[id]
+	}
+}
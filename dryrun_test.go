@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestDryRunLeavesStoreUnchanged(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	movie := ts.create(movieJSON("Heat"))
+	path := "/v1/movies/" + movie.ID
+	before := readBody(t, ts.do("GET", "/v1/movies/export", ""))
+
+	resp := ts.do("POST", "/v1/movies?dry_run=true", movieJSON("Ronin"))
+	expectStatus(t, resp, http.StatusOK)
+	if got := resp.Header.Get("X-Dry-Run"); got != "true" {
+		t.Errorf("X-Dry-Run = %q, want true", got)
+	}
+	preview := decodeBody[Movie](t, resp)
+	if uuid.Validate(preview.ID) != nil || preview.Title != "Ronin" || preview.Version != 1 || preview.CreatedAt.IsZero() {
+		t.Errorf("dry-run create = %+v, want a valid movie with a generated ID", preview)
+	}
+
+	for _, req := range []struct{ method, path, body string }{
+		{"POST", "/v1/movies/bulk", "[" + movieJSON("Ronin") + "]"},
+		{"PUT", path, `{"isbn":"0306406152","title":"Tenet","director":{"firstName":"Christopher","lastName":"Nolan"},"version":1}`},
+		{"PUT", "/v1/movies/new-id", movieJSON("Tenet")},
+		{"PATCH", path, `{"title":"Tenet","version":1}`},
+	} {
+		resp := ts.do(req.method, req.path+"?dry_run=true", req.body)
+		expectStatus(t, resp, http.StatusOK)
+		if got := resp.Header.Get("X-Dry-Run"); got != "true" {
+			t.Errorf("%s %s: X-Dry-Run = %q, want true", req.method, req.path, got)
+		}
+	}
+	// Dry runs validate like real writes do.
+	expectStatus(t, ts.do("POST", "/v1/movies?dry_run=true", `{"isbn":"0306406152","title":""}`), http.StatusUnprocessableEntity)
+	expectStatus(t, ts.do("PATCH", path+"?dry_run=true", `{"title":"Tenet","version":7}`), http.StatusConflict)
+
+	if after := readBody(t, ts.do("GET", "/v1/movies/export", "")); after != before {
+		t.Errorf("store changed by dry runs:\nbefore %s\nafter  %s", before, after)
+	}
+}
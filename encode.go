@@ -0,0 +1,17 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// newJSONEncoder returns an encoder for a response to r. It indents its
+// output when the request asks for ?pretty=true, which is easier to read
+// in a terminal; compact output stays the default to save bandwidth.
+func newJSONEncoder(w http.ResponseWriter, r *http.Request) *json.Encoder {
+	enc := json.NewEncoder(w)
+	if queryBool(r.URL.Query(), "pretty") {
+		enc.SetIndent("", "  ")
+	}
+	return enc
+}
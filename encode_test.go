@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestPrettyJSON(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	movie := ts.create(movieJSON("Heat"))
+	for _, path := range []string{"/v1/movies/" + movie.ID, "/v1/movies", "/v1/movies/count"} {
+		compact := readBody(t, ts.do("GET", path, ""))
+		if strings.Contains(compact, "\n ") {
+			t.Errorf("%s is indented by default: %s", path, compact)
+		}
+		resp := ts.do("GET", path+"?pretty=true", "")
+		expectStatus(t, resp, http.StatusOK)
+		pretty := readBody(t, resp)
+		if !strings.Contains(pretty, "\n  \"") {
+			t.Errorf("%s?pretty=true is not indented: %s", path, pretty)
+		}
+		if strings.Join(strings.Fields(pretty), "") != strings.Join(strings.Fields(compact), "") {
+			t.Errorf("%s: pretty and compact bodies differ:\n%s\n%s", path, pretty, compact)
+		}
+	}
+	if body := readBody(t, ts.do("GET", "/v1/movies/"+movie.ID+"?pretty=false", "")); strings.Contains(body, "\n ") {
+		t.Errorf("pretty=false is indented: %s", body)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// errorBody is the JSON envelope every error response uses.
+type errorBody struct {
+	Error errorDetail `json:"error"`
+}
+
+// errorDetail is the error itself. Code names the status in snake case,
+// such as "not_found", so clients can branch on it without parsing
+// Message, which is meant for people.
+type errorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// errorCode returns the Code for status, derived from its status text.
+func errorCode(status int) string {
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+// writeJSONError replies with status and a JSON error envelope carrying
+// message.
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Del("Content-Length")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorBody{Error: errorDetail{Code: errorCode(status), Message: message}})
+}
+
+// writeStoreError answers a store call that failed with err. A request
+// that ran out of time gets 504 and one that was cancelled gets 503, which
+// only the logs and metrics see since the client has gone; anything else
+// is the store's fault and gets 500. The store's own error can name
+// tables, files or hosts, so it goes to the log rather than the client.
+func writeStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		writeJSONError(w, http.StatusGatewayTimeout, "request timed out")
+	case errors.Is(err, context.Canceled):
+		writeJSONError(w, http.StatusServiceUnavailable, "request cancelled")
+	default:
+		slog.Error("store call failed",
+			"request_id", requestIDFromContext(r.Context()),
+			"method", r.Method,
+			"path", r.URL.Path,
+			"error", err,
+		)
+		writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestUnknownRoute(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	resp := ts.do("GET", "/v1/nope", "")
+	expectStatus(t, resp, http.StatusNotFound)
+	if got := resp.Header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	want := `{"error":{"code":"not_found","message":"route not found"}}` + "\n"
+	if got := readBody(t, resp); got != want {
+		t.Errorf("body = %s, want %s", got, want)
+	}
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	tests := []struct {
+		method, path, body string
+		status             int
+		want               string
+	}{
+		{"GET", "/v1/movies/missing", "", http.StatusNotFound, `{"error":{"code":"not_found","message":"Movie not found"}}`},
+		{"GET", "/v1/movies?limit=x", "", http.StatusBadRequest, `{"error":{"code":"bad_request","message":"limit must be a non-negative integer"}}`},
+		{"POST", "/v1/movies", `{"title":`, http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		resp := ts.do(tt.method, tt.path, tt.body)
+		expectStatus(t, resp, tt.status)
+		if got := resp.Header.Get("Content-Type"); got != "application/json" {
+			t.Errorf("%s %s: Content-Type = %q", tt.method, tt.path, got)
+		}
+		body := readBody(t, resp)
+		if tt.want != "" && strings.TrimSpace(body) != tt.want {
+			t.Errorf("%s %s: body = %s, want %s", tt.method, tt.path, body, tt.want)
+		}
+		var envelope errorBody
+		if err := json.Unmarshal([]byte(body), &envelope); err != nil || envelope.Error.Code != errorCode(tt.status) || envelope.Error.Message == "" {
+			t.Errorf("%s %s: body %s is not an error envelope", tt.method, tt.path, body)
+		}
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	for status, want := range map[int]string{
+		http.StatusBadRequest:            "bad_request",
+		http.StatusMethodNotAllowed:      "method_not_allowed",
+		http.StatusRequestEntityTooLarge: "request_entity_too_large",
+		http.StatusInsufficientStorage:   "insufficient_storage",
+	} {
+		if got := errorCode(status); got != want {
+			t.Errorf("errorCode(%d) = %q, want %q", status, got, want)
+		}
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	tests := []struct {
+		method, path, allow string
+	}{
+		{"DELETE", "/v1/movies", "GET, HEAD, POST"},
+		{"PATCH", "/movies", "GET, HEAD, POST"},
+		{"POST", "/v1/movies/1", "GET, HEAD, PUT, PATCH, DELETE"},
+		{"DELETE", "/v1/movies/1/restore", "POST"},
+		{"PUT", "/healthz", "GET"},
+	}
+	for _, tt := range tests {
+		resp := ts.do(tt.method, tt.path, "")
+		expectStatus(t, resp, http.StatusMethodNotAllowed)
+		if got := resp.Header.Get("Allow"); got != tt.allow {
+			t.Errorf("%s %s: Allow = %q, want %q", tt.method, tt.path, got, tt.allow)
+		}
+		want := `{"error":{"code":"method_not_allowed","message":"Method not allowed"}}` + "\n"
+		if got := readBody(t, resp); got != want {
+			t.Errorf("%s %s: body = %s, want %s", tt.method, tt.path, got, want)
+		}
+	}
+}
+
+// failingStore is an empty store whose reads all fail with err.
+type failingStore struct {
+	MovieStore
+	err error
+}
+
+func (s failingStore) GetAll(ctx context.Context) ([]Movie, error) {
+	return nil, s.err
+}
+
+func (s failingStore) GetByID(ctx context.Context, id string) (Movie, bool, error) {
+	return Movie{}, false, s.err
+}
+
+func TestStoreErrors(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{errors.New("disk I/O error"), http.StatusInternalServerError},
+		{context.Canceled, http.StatusServiceUnavailable},
+		{fmt.Errorf("query: %w", context.DeadlineExceeded), http.StatusGatewayTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			ts := startServer(t, failingStore{MovieStore: newMemoryStore(nil, 0), err: tt.err}, testConfig())
+			// Neither is an empty list or a missing movie.
+			for _, path := range []string{"/v1/movies", "/v1/movies/1"} {
+				resp := ts.do("GET", path, "")
+				expectStatus(t, resp, tt.status)
+				if got := decodeBody[errorBody](t, resp).Error.Code; got != errorCode(tt.status) {
+					t.Errorf("GET %s: error code = %q", path, got)
+				}
+			}
+		})
+	}
+}
+
+func TestStoreErrorNotLeaked(t *testing.T) {
+	logs := captureLogs(t)
+	ts := startServer(t, failingStore{MovieStore: newMemoryStore(nil, 0), err: errors.New("open /var/lib/movies.db: disk I/O error")}, testConfig())
+
+	resp := ts.do("GET", "/v1/movies", "", "X-Request-ID", "req-42")
+	expectStatus(t, resp, http.StatusInternalServerError)
+	if got := decodeBody[errorBody](t, resp).Error.Message; got != "Internal server error" {
+		t.Errorf("message = %q, want the store's error kept out of it", got)
+	}
+
+	var found bool
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var entry map[string]any
+		json.Unmarshal([]byte(line), &entry)
+		if entry["msg"] == "store call failed" {
+			found = true
+			if entry["request_id"] != "req-42" || !strings.Contains(fmt.Sprint(entry["error"]), "disk I/O error") {
+				t.Errorf("log entry = %v, want the request ID and the store's error", entry)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("no store error logged in %q", logs)
+	}
+}
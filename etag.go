@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// movieETag is a strong ETag derived from the movie's JSON encoding.
+func movieETag(movie Movie) string {
+	b, _ := json.Marshal(movie)
+	sum := md5.Sum(b)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// etagMatches reports whether the If-Match or If-None-Match header value
+// lists etag, treating "*" as matching anything.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch replies 412 and returns false when the request carries an
+// If-Match header that doesn't match the movie's current ETag.
+func checkIfMatch(w http.ResponseWriter, r *http.Request, current Movie) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" || etagMatches(header, movieETag(current)) {
+		return true
+	}
+	writeJSONError(w, http.StatusPreconditionFailed, "Movie has been modified")
+	return false
+}
+
+// touch notes that the movie collection, or a director embedded in it,
+// has just changed.
+func (h *handler) touch() {
+	h.modified.Store(time.Now().UnixNano())
+}
+
+// lastModified is when the collection last changed, or when the server
+// started if it hasn't since, as the store may have been changed before.
+func (h *handler) lastModified() time.Time {
+	return time.Unix(0, h.modified.Load()).UTC()
+}
+
+// checkNotModified sets Last-Modified and replies 304, returning true,
+// when the request's If-Modified-Since is at or after modified. HTTP dates
+// only have whole seconds, so a change made within the same second as the
+// client's copy goes unnoticed until the next one.
+func checkNotModified(w http.ResponseWriter, r *http.Request, modified time.Time) bool {
+	modified = modified.Truncate(time.Second)
+	w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || modified.After(since) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
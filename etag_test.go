@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestETagNotModified(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	id := ts.create(movieJSON("Heat")).ID
+
+	resp := ts.do("GET", "/v1/movies/"+id, "")
+	expectStatus(t, resp, http.StatusOK)
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag on GET")
+	}
+	resp = ts.do("GET", "/v1/movies/"+id, "", "If-None-Match", etag)
+	expectStatus(t, resp, http.StatusNotModified)
+	if body := readBody(t, resp); body != "" {
+		t.Errorf("304 body = %q", body)
+	}
+
+	ts.do("PATCH", "/v1/movies/"+id, `{"title":"Ronin","version":1}`)
+	resp = ts.do("GET", "/v1/movies/"+id, "", "If-None-Match", etag)
+	expectStatus(t, resp, http.StatusOK)
+	if resp.Header.Get("ETag") == etag {
+		t.Error("ETag unchanged after an update")
+	}
+}
+
+func TestIfMatchPreconditionFailed(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	id := ts.create(movieJSON("Heat")).ID
+	stale := ts.do("GET", "/v1/movies/"+id, "").Header.Get("ETag")
+	ts.do("PATCH", "/v1/movies/"+id, `{"title":"Ronin","version":1}`)
+
+	update := `{"isbn":"0306406152","title":"Thief","director":{"firstName":"Michael","lastName":"Mann"},"version":2}`
+	expectStatus(t, ts.do("PUT", "/v1/movies/"+id, update, "If-Match", stale), http.StatusPreconditionFailed)
+	expectStatus(t, ts.do("DELETE", "/v1/movies/"+id, "", "If-Match", stale), http.StatusPreconditionFailed)
+
+	current := ts.do("GET", "/v1/movies/"+id, "").Header.Get("ETag")
+	expectStatus(t, ts.do("PUT", "/v1/movies/"+id, update, "If-Match", current), http.StatusOK)
+	expectStatus(t, ts.do("DELETE", "/v1/movies/"+id, "", "If-Match", "*"), http.StatusOK)
+}
+
+func TestIfModifiedSince(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	ts.create(movieJSON("Heat"))
+
+	resp := ts.do("GET", "/v1/movies", "")
+	expectStatus(t, resp, http.StatusOK)
+	lastModified := resp.Header.Get("Last-Modified")
+	modified, err := http.ParseTime(lastModified)
+	if err != nil {
+		t.Fatalf("Last-Modified %q: %v", lastModified, err)
+	}
+
+	for _, since := range []time.Time{modified, modified.Add(time.Hour)} {
+		resp = ts.do("GET", "/v1/movies", "", "If-Modified-Since", since.Format(http.TimeFormat))
+		expectStatus(t, resp, http.StatusNotModified)
+		if body := readBody(t, resp); body != "" {
+			t.Errorf("304 body = %q", body)
+		}
+	}
+	resp = ts.do("GET", "/v1/movies", "", "If-Modified-Since", modified.Add(-time.Second).Format(http.TimeFormat))
+	expectStatus(t, resp, http.StatusOK)
+
+	// Last-Modified has whole seconds, so the change has to land in a
+	// later one to show.
+	time.Sleep(time.Until(modified.Add(time.Second)))
+	ts.create(movieJSON("Ronin"))
+	resp = ts.do("GET", "/v1/movies", "", "If-Modified-Since", lastModified)
+	expectStatus(t, resp, http.StatusOK)
+	if got := decodeBody[testMovieList](t, resp).Total; got != 2 {
+		t.Errorf("fresh list total = %d, want 2", got)
+	}
+	if resp.Header.Get("Last-Modified") == lastModified {
+		t.Error("Last-Modified unchanged after a create")
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// MovieEvent is one change GET /movies/events streams. Type is the audit
+// action, and Movie the movie as it is after the change, which for a
+// delete carries its deletedAt. An import names no single movie, so it
+// comes without one.
+type MovieEvent struct {
+	Type    string `json:"type"`
+	MovieID string `json:"movieId,omitempty"`
+	Movie   *Movie `json:"movie,omitempty"`
+}
+
+// eventBufferSize is how many events a subscriber may fall behind by
+// before further events are dropped for it.
+const eventBufferSize = 16
+
+// eventHeartbeat is how often an idle stream gets a comment line, so
+// proxies don't time out the connection.
+const eventHeartbeat = 30 * time.Second
+
+// eventBroker fans published events out to every subscriber. Publishing
+// never blocks: a subscriber whose buffer is full misses the event rather
+// than holding up the request that made the change.
+type eventBroker struct {
+	mu     sync.Mutex
+	subs   map[chan MovieEvent]struct{}
+	closed bool
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{subs: make(map[chan MovieEvent]struct{})}
+}
+
+// subscribe returns a channel receiving every event published from now
+// on, and a function that unsubscribes it. The channel is closed once the
+// broker is, so streams end when the server shuts down.
+func (b *eventBroker) subscribe() (<-chan MovieEvent, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch := make(chan MovieEvent, eventBufferSize)
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	b.subs[ch] = struct{}{}
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[ch]; ok {
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (b *eventBroker) publish(event MovieEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// close ends every subscription and refuses new ones.
+func (b *eventBroker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for ch := range b.subs {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+// streamEvents serves GET /movies/events as Server-Sent Events, one event
+// per change named after its type, until the client goes away or the
+// server shuts down.
+func (h *handler) streamEvents(w http.ResponseWriter, r *http.Request) {
+	events, unsubscribe := h.events.subscribe()
+	defer unsubscribe()
+
+	rc := http.NewResponseController(w)
+	// The stream outlives any write timeout meant for ordinary responses.
+	rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				panic(err) // MovieEvent always marshals.
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
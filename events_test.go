@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestStreamEvents(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", ts.URL+"/v1/movies/events", nil)
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	expectStatus(t, resp, http.StatusOK)
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Content-Type = %q", got)
+	}
+
+	// The subscription exists once the headers are in.
+	movie := ts.create(movieJSON("Heat"))
+	ts.do("DELETE", "/v1/movies/"+movie.ID, "")
+
+	lines := bufio.NewScanner(resp.Body)
+	next := func() (string, MovieEvent) {
+		t.Helper()
+		var name string
+		var event MovieEvent
+		for lines.Scan() {
+			line := lines.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+					t.Fatalf("data %s: %v", line, err)
+				}
+			case line == "" && name != "":
+				return name, event
+			}
+		}
+		t.Fatalf("stream ended: %v", lines.Err())
+		return "", MovieEvent{}
+	}
+	name, event := next()
+	if name != auditCreate || event.Type != auditCreate || event.MovieID != movie.ID || event.Movie == nil || event.Movie.Title != "Heat" {
+		t.Errorf("first event %s: %+v", name, event)
+	}
+	name, event = next()
+	if name != auditDelete || event.MovieID != movie.ID || event.Movie == nil || event.Movie.DeletedAt == nil {
+		t.Errorf("second event %s: %+v", name, event)
+	}
+}
+
+func TestEventBroker(t *testing.T) {
+	b := newEventBroker()
+	events, unsubscribe := b.subscribe()
+
+	// A subscriber that doesn't keep up misses events instead of blocking
+	// publish.
+	for i := 0; i < eventBufferSize+5; i++ {
+		b.publish(MovieEvent{Type: auditCreate})
+	}
+	if got := len(events); got != eventBufferSize {
+		t.Errorf("buffered %d events, want %d", got, eventBufferSize)
+	}
+	unsubscribe()
+	unsubscribe()
+	b.publish(MovieEvent{Type: auditUpdate})
+
+	other, _ := b.subscribe()
+	b.close()
+	if _, ok := <-other; ok {
+		t.Error("subscription still open after close")
+	}
+	if late, _ := b.subscribe(); func() bool { _, ok := <-late; return ok }() {
+		t.Error("subscribing after close got an open channel")
+	}
+}
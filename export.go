@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// exportMovies sends the whole collection, soft-deleted movies included,
+// as a JSON file that importMovies can restore.
+func (h *handler) exportMovies(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="movies.json"`)
+	movies, err := h.store.GetAll(r.Context())
+	if err != nil {
+		w.Header().Del("Content-Disposition")
+		writeStoreError(w, r, err)
+		return
+	}
+	newJSONEncoder(w, r).Encode(movies)
+}
+
+// importMovies replaces the whole collection with the uploaded JSON
+// array. Every movie is validated first and a single invalid one rejects
+// the file, leaving the collection untouched.
+func (h *handler) importMovies(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var movies []Movie
+	if err := decodeJSONWithSchema(r, movieListSchema, &movies); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	now := time.Now().UTC()
+	var invalid []indexedFieldErrors
+	for i := range movies {
+		// checkMovie clears DeletedAt, but a restored backup should keep
+		// its soft-deleted movies deleted.
+		deletedAt := movies[i].DeletedAt
+		errs, err := h.checkMovie(r.Context(), &movies[i])
+		if err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+		if errs != nil {
+			invalid = append(invalid, indexedFieldErrors{Index: i, Errors: errs})
+		}
+		movies[i].DeletedAt = deletedAt
+		if movies[i].CreatedAt.IsZero() {
+			movies[i].CreatedAt = now
+		}
+		if movies[i].UpdatedAt.IsZero() {
+			movies[i].UpdatedAt = movies[i].CreatedAt
+		}
+		if movies[i].Version < 1 {
+			movies[i].Version = 1
+		}
+	}
+	if invalid != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		newJSONEncoder(w, r).Encode(invalid)
+		return
+	}
+	movies, err := h.store.ReplaceAll(r.Context(), movies)
+	if errors.Is(err, ErrDuplicateID) {
+		writeJSONError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if errors.Is(err, ErrStoreFull) {
+		writeJSONError(w, http.StatusInsufficientStorage, err.Error())
+		return
+	}
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	h.record(r, auditImport, "", nil, nil)
+	newJSONEncoder(w, r).Encode(map[string]int{"imported": len(movies)})
+}
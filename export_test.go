@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestExportImportRoundTrip(t *testing.T) {
+	src := newTestServer(t, testConfig())
+	movies := src.createMovies(3)
+	src.do("DELETE", "/v1/movies/"+movies[1].ID, "")
+
+	resp := src.do("GET", "/v1/movies/export", "")
+	expectStatus(t, resp, http.StatusOK)
+	if got := resp.Header.Get("Content-Disposition"); got != `attachment; filename="movies.json"` {
+		t.Errorf("Content-Disposition = %q", got)
+	}
+	backup := readBody(t, resp)
+
+	dst := newTestServer(t, testConfig())
+	dst.createMovies(5)
+	resp = dst.do("POST", "/v1/movies/import", backup)
+	expectStatus(t, resp, http.StatusOK)
+	if got := decodeBody[map[string]int](t, resp)["imported"]; got != 3 {
+		t.Errorf("imported = %d, want 3", got)
+	}
+	// The import replaced the collection, deleted movie and all.
+	restored := dst.list("?include_deleted=true").Data
+	if len(restored) != 3 {
+		t.Fatalf("restored %d movies, want 3", len(restored))
+	}
+	for i, m := range restored {
+		want := movies[i]
+		if i == 1 {
+			if m.DeletedAt == nil {
+				t.Error("deleted movie came back live")
+			}
+			continue
+		}
+		if !sameMovie(m, want) {
+			t.Errorf("movie %d = %+v, want %+v", i, m, want)
+		}
+	}
+	if got := readBody(t, dst.do("GET", "/v1/movies/export", "")); got != backup {
+		t.Errorf("second export differs:\n%s\n%s", got, backup)
+	}
+}
+
+func TestImportRejectsInvalidFile(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	before := ts.createMovies(2)
+	bad := fmt.Sprintf(`[%s,{"isbn":"0306406152","title":"","director":{"firstName":"Michael","lastName":"Mann"}}]`, movieJSON("Heat"))
+	resp := ts.do("POST", "/v1/movies/import", bad)
+	expectStatus(t, resp, http.StatusUnprocessableEntity)
+	errs := decodeBody[[]indexedFieldErrors](t, resp)
+	if len(errs) != 1 || errs[0].Index != 1 {
+		t.Errorf("errors = %+v, want one for movie 1", errs)
+	}
+	if got := ids(ts.list("").Data); fmt.Sprint(got) != fmt.Sprint(ids(before)) {
+		t.Errorf("movies after a rejected import = %v, want %v", got, ids(before))
+	}
+
+	dup := `[{"id":"a","isbn":"0306406152","title":"Heat","director":{"firstName":"Michael","lastName":"Mann"}},
+		{"id":"a","isbn":"0306406152","title":"Ronin","director":{"firstName":"John","lastName":"Frankenheimer"}}]`
+	expectStatus(t, ts.do("POST", "/v1/movies/import", dup), http.StatusConflict)
+	if total := ts.list("").Total; total != 2 {
+		t.Errorf("total after a duplicate-ID import = %d, want 2", total)
+	}
+}
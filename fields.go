@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// movieFields are the JSON names the fields query parameter can select.
+var movieFields = map[string]bool{
+	"id":         true,
+	"isbn":       true,
+	"title":      true,
+	"director":   true,
+	"directorId": true,
+	"genres":     true,
+	"year":       true,
+	"createdAt":  true,
+	"updatedAt":  true,
+	"deletedAt":  true,
+	"version":    true,
+}
+
+// parseFields reads the comma-separated fields query parameter. A nil
+// result means the parameter was absent and every field should be sent.
+func parseFields(query url.Values) ([]string, error) {
+	raw := query.Get("fields")
+	if raw == "" {
+		return nil, nil
+	}
+	var fields []string
+	for _, f := range strings.Split(raw, ",") {
+		f = strings.TrimSpace(f)
+		if !movieFields[f] {
+			return nil, fmt.Errorf("unknown field %q", f)
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// selectFields returns movie as a JSON object holding only fields. Fields
+// the movie omits, such as an unset deletedAt, stay omitted.
+func selectFields(movie Movie, fields []string) map[string]json.RawMessage {
+	b, err := json.Marshal(movie)
+	if err != nil {
+		panic(err) // Movie always marshals.
+	}
+	var all map[string]json.RawMessage
+	json.Unmarshal(b, &all)
+	out := make(map[string]json.RawMessage, len(fields))
+	for _, f := range fields {
+		if v, ok := all[f]; ok {
+			out[f] = v
+		}
+	}
+	return out
+}
+
+// sparseMovies applies selectFields to every movie, or returns movies
+// unchanged when fields is nil.
+func sparseMovies(movies []Movie, fields []string) any {
+	if fields == nil {
+		return movies
+	}
+	out := make([]map[string]json.RawMessage, len(movies))
+	for i, m := range movies {
+		out[i] = selectFields(m, fields)
+	}
+	return out
+}
+
+// editableFields are the movie fields clients set themselves, in the
+// order changedFields lists them. The rest are bookkeeping that changes
+// on every write.
+var editableFields = []string{"isbn", "title", "director", "directorId", "genres", "year"}
+
+// movieUpdate is the response to PUT and PATCH: the stored movie and the
+// editable fields that differ from what was there before, an empty list
+// when nothing did.
+type movieUpdate struct {
+	Movie   Movie    `json:"movie"`
+	Changed []string `json:"changed"`
+}
+
+// newMovieUpdate describes the change from before to after. A movie that
+// PUT created has a zero before, so every field it sets counts as changed.
+func newMovieUpdate(before, after Movie) movieUpdate {
+	return movieUpdate{Movie: after, Changed: changedFields(before, after)}
+}
+
+// changedFields lists the editableFields whose JSON differs between
+// before and after. A field one of them omits, such as an unset
+// directorId, counts as different from any value the other has.
+func changedFields(before, after Movie) []string {
+	old := selectFields(before, editableFields)
+	cur := selectFields(after, editableFields)
+	changed := []string{}
+	for _, f := range editableFields {
+		if !bytes.Equal(old[f], cur[f]) {
+			changed = append(changed, f)
+		}
+	}
+	return changed
+}
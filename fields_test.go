@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+// keys returns the sorted keys of a decoded JSON object.
+func keys(obj map[string]json.RawMessage) []string {
+	out := make([]string, 0, len(obj))
+	for k := range obj {
+		out = append(out, k)
+	}
+	slices.Sort(out)
+	return out
+}
+
+func TestSparseFields(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	movie := ts.create(movieJSON("Heat"))
+
+	resp := ts.do("GET", "/v1/movies/"+movie.ID+"?fields=title,id", "")
+	expectStatus(t, resp, http.StatusOK)
+	got := decodeBody[map[string]json.RawMessage](t, resp)
+	if fmt.Sprint(keys(got)) != "[id title]" || string(got["title"]) != `"Heat"` {
+		t.Errorf("movie with fields=title,id = %s", got)
+	}
+
+	resp = ts.do("GET", "/v1/movies?fields=isbn", "")
+	expectStatus(t, resp, http.StatusOK)
+	list := decodeBody[struct {
+		Data  []map[string]json.RawMessage `json:"data"`
+		Total int                          `json:"total"`
+	}](t, resp)
+	if len(list.Data) != 1 || fmt.Sprint(keys(list.Data[0])) != "[isbn]" || list.Total != 1 {
+		t.Errorf("list with fields=isbn = %+v", list)
+	}
+
+	// A field the movie leaves out stays out.
+	resp = ts.do("GET", "/v1/movies/"+movie.ID+"?fields=deletedAt", "")
+	if got := decodeBody[map[string]json.RawMessage](t, resp); len(got) != 0 {
+		t.Errorf("fields=deletedAt on a live movie = %s, want {}", got)
+	}
+
+	for _, path := range []string{"/v1/movies/" + movie.ID + "?fields=id,rating", "/v1/movies?fields=id,"} {
+		expectStatus(t, ts.do("GET", path, ""), http.StatusBadRequest)
+	}
+}
+
+func TestChangedFields(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	movie := ts.create(`{"isbn":"0306406152","title":"Heat","director":{"firstName":"Michael","lastName":"Mann"},"year":1995}`)
+	path := "/v1/movies/" + movie.ID
+	tests := []struct {
+		method, body string
+		changed      string
+	}{
+		{"PATCH", `{"title":"Heat (1995)","version":1}`, "[title]"},
+		{"PATCH", `{"title":"Heat (1995)","version":2}`, "[]"},
+		{"PUT", `{"isbn":"0306406152","title":"Heat (1995)","director":{"firstName":"Michael","lastName":"Mann"},"year":1995,"version":3}`, "[]"},
+		{"PUT", `{"isbn":"9780306406157","title":"Heat (1995)","director":{"firstName":"Michael","lastName":"Mann"},"version":4}`, "[isbn year]"},
+		{"PATCH", `{"director":{"firstName":"Michael K.","lastName":"Mann"},"genres":["crime"],"version":5}`, "[director genres]"},
+	}
+	for _, tt := range tests {
+		resp := ts.do(tt.method, path, tt.body)
+		expectStatus(t, resp, http.StatusOK)
+		update := decodeBody[movieUpdate](t, resp)
+		if got := fmt.Sprint(update.Changed); got != tt.changed || update.Changed == nil {
+			t.Errorf("%s %s: changed = %#v, want %s", tt.method, tt.body, update.Changed, tt.changed)
+		}
+		if update.Movie.ID != movie.ID {
+			t.Errorf("%s: envelope movie = %+v", tt.method, update.Movie)
+		}
+	}
+
+	// A PUT that creates counts everything it sets as changed.
+	resp := ts.do("PUT", "/v1/movies/new", movieJSON("Ronin"))
+	expectStatus(t, resp, http.StatusCreated)
+	if got := fmt.Sprint(decodeBody[movieUpdate](t, resp).Changed); got != "[isbn title director]" {
+		t.Errorf("create via PUT changed = %s", got)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGzip(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	movies := ts.createMovies(30)
+
+	plain := readBody(t, ts.do("GET", "/v1/movies", ""))
+	resp := ts.do("GET", "/v1/movies", "", "Accept-Encoding", "gzip")
+	expectStatus(t, resp, http.StatusOK)
+	if got := resp.Header.Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", got)
+	}
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(zr)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != plain {
+		t.Errorf("decompressed body differs from the plain one:\n%s\n%s", body, plain)
+	}
+
+	// A single movie is below gzipMinSize.
+	resp = ts.do("GET", "/v1/movies/"+movies[0].ID, "", "Accept-Encoding", "gzip")
+	expectStatus(t, resp, http.StatusOK)
+	if got := resp.Header.Get("Content-Encoding"); got != "" {
+		t.Errorf("small body Content-Encoding = %q, want none", got)
+	}
+	if got := decodeBody[Movie](t, resp).ID; got != movies[0].ID {
+		t.Errorf("small body ID = %q, want %q", got, movies[0].ID)
+	}
+}
+
+func TestGzipSkipsCompressedContent(t *testing.T) {
+	h := gzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte(strings.Repeat("x", 2*gzipMinSize)))
+	}))
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	h.ServeHTTP(rec, req)
+	if got := rec.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("image Content-Encoding = %q, want none", got)
+	}
+	if rec.Body.Len() != 2*gzipMinSize {
+		t.Errorf("image body is %d bytes, want %d", rec.Body.Len(), 2*gzipMinSize)
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	for header, want := range map[string]bool{
+		"":                  false,
+		"gzip":              true,
+		"deflate, gzip":     true,
+		"gzip;q=0.5":        true,
+		"gzip; q=0":         false,
+		"br":                false,
+		"identity, x-gzip2": false,
+	} {
+		if got := acceptsGzip(header); got != want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
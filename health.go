@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net/http"
+)
+
+// pinger is implemented by stores that depend on an external resource the
+// health check should verify.
+type pinger interface {
+	Ping() error
+}
+
+func (h *handler) healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if p, ok := h.store.(pinger); ok {
+		if err := p.Ping(); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			newJSONEncoder(w, r).Encode(map[string]string{"status": "unavailable"})
+			return
+		}
+	}
+	newJSONEncoder(w, r).Encode(map[string]string{"status": "ok"})
+}
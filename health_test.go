@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// pingStore is a memory store whose backing resource answers pings with
+// err.
+type pingStore struct {
+	*memoryStore
+	err error
+}
+
+func (s pingStore) Ping() error {
+	return s.err
+}
+
+func TestHealthz(t *testing.T) {
+	tests := []struct {
+		store  MovieStore
+		status int
+		body   string
+	}{
+		{newMemoryStore(nil, 0), http.StatusOK, `{"status":"ok"}`},
+		{pingStore{newMemoryStore(nil, 0), nil}, http.StatusOK, `{"status":"ok"}`},
+		{pingStore{newMemoryStore(nil, 0), errors.New("connection refused")}, http.StatusServiceUnavailable, `{"status":"unavailable"}`},
+		{newTestSQLiteStore(t), http.StatusOK, `{"status":"ok"}`},
+	}
+	for _, tt := range tests {
+		ts := startServer(t, tt.store, testConfig())
+		resp := ts.do("GET", "/healthz", "")
+		expectStatus(t, resp, tt.status)
+		if got := strings.TrimSpace(readBody(t, resp)); got != tt.body {
+			t.Errorf("%T: body = %s, want %s", tt.store, got, tt.body)
+		}
+	}
+
+	// A closed database fails the ping.
+	s := newTestSQLiteStore(t)
+	s.Close()
+	ts := startServer(t, s, testConfig())
+	expectStatus(t, ts.do("GET", "/healthz", ""), http.StatusServiceUnavailable)
+}
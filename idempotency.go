@@ -0,0 +1,77 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultIdempotencyTTL is how long an Idempotency-Key is remembered
+// unless IDEMPOTENCY_TTL says otherwise.
+const defaultIdempotencyTTL = 24 * time.Hour
+
+// idempotencyCache remembers the movie created for each Idempotency-Key
+// so a retried POST returns it instead of creating another one. Keys are
+// scoped to the actor that sent them, so one API key's client can't
+// replay, and so read, the movies another one created.
+type idempotencyCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[idempotencyKey]idempotencyEntry
+}
+
+// idempotencyKey is an Idempotency-Key as sent by one actor, the API key
+// the request was authorized with.
+type idempotencyKey struct {
+	actor, key string
+}
+
+type idempotencyEntry struct {
+	movie   Movie
+	expires time.Time
+}
+
+func newIdempotencyCache(ttl time.Duration) *idempotencyCache {
+	return &idempotencyCache{ttl: ttl, entries: make(map[idempotencyKey]idempotencyEntry)}
+}
+
+// get returns the movie actor already created with key, if the key is
+// known and hasn't expired.
+func (c *idempotencyCache) get(actor, key string) (Movie, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[idempotencyKey{actor, key}]
+	if !ok || !time.Now().Before(e.expires) {
+		return Movie{}, false
+	}
+	return e.movie, true
+}
+
+// do returns the movie actor already created with key, with replayed set,
+// or calls create and remembers its result. The lock is held across
+// create so concurrent retries with the same key can't both create a
+// movie.
+func (c *idempotencyCache) do(actor, key string, create func() (Movie, error)) (movie Movie, replayed bool, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	id := idempotencyKey{actor, key}
+	if e, ok := c.entries[id]; ok && now.Before(e.expires) {
+		return e.movie, true, nil
+	}
+	c.evictExpired(now)
+	movie, err = create()
+	if err != nil {
+		return Movie{}, false, err
+	}
+	c.entries[id] = idempotencyEntry{movie: movie, expires: now.Add(c.ttl)}
+	return movie, false, nil
+}
+
+// evictExpired drops expired keys; callers must hold the lock.
+func (c *idempotencyCache) evictExpired(now time.Time) {
+	for id, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, id)
+		}
+	}
+}
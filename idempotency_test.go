@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+
+	resp := ts.do("POST", "/v1/movies", movieJSON("Heat"), "Idempotency-Key", "k1")
+	expectStatus(t, resp, http.StatusCreated)
+	first := decodeBody[Movie](t, resp)
+
+	resp = ts.do("POST", "/v1/movies", movieJSON("Heat"), "Idempotency-Key", "k1")
+	expectStatus(t, resp, http.StatusOK)
+	if got := decodeBody[Movie](t, resp).ID; got != first.ID {
+		t.Errorf("repeated key returned movie %s, want %s", got, first.ID)
+	}
+
+	resp = ts.do("POST", "/v1/movies", movieJSON("Heat"), "Idempotency-Key", "k2")
+	expectStatus(t, resp, http.StatusCreated)
+	if got := decodeBody[Movie](t, resp).ID; got == first.ID {
+		t.Errorf("fresh key returned the first movie %s", got)
+	}
+}
+
+func TestIdempotencyKeyScopedToAPIKey(t *testing.T) {
+	cfg := testConfig()
+	cfg.APIKeys = []string{"alice", "bob"}
+	ts := newTestServer(t, cfg)
+
+	resp := ts.do("POST", "/v1/movies", movieJSON("Heat"), "X-API-Key", "alice", "Idempotency-Key", "k1")
+	expectStatus(t, resp, http.StatusCreated)
+	alices := decodeBody[Movie](t, resp)
+
+	resp = ts.do("POST", "/v1/movies", movieJSON("Ronin"), "X-API-Key", "bob", "Idempotency-Key", "k1")
+	expectStatus(t, resp, http.StatusCreated)
+	if bobs := decodeBody[Movie](t, resp); bobs.ID == alices.ID || bobs.Title != "Ronin" {
+		t.Errorf("bob's request got %+v, want a new movie of his own", bobs)
+	}
+}
+
+func TestIdempotencyCacheExpires(t *testing.T) {
+	c := newIdempotencyCache(10 * time.Millisecond)
+	calls := 0
+	create := func() (Movie, error) {
+		calls++
+		return Movie{ID: "1"}, nil
+	}
+	if _, replayed, _ := c.do("", "k", create); replayed {
+		t.Error("first use of a key was replayed")
+	}
+	if _, replayed, _ := c.do("", "k", create); !replayed {
+		t.Error("second use of a key was not replayed")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, replayed, _ := c.do("", "k", create); replayed {
+		t.Error("expired key was replayed")
+	}
+	if calls != 2 {
+		t.Errorf("create called %d times, want 2", calls)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strconv"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+)
+
+// IDGenerator makes the IDs stores give records created without one.
+// Next may return an ID that is taken; the store then asks again.
+type IDGenerator interface {
+	Next() string
+}
+
+// uuidGenerator makes UUIDv4s read from crypto/rand, so separate
+// processes never share a sequence. It is the default.
+type uuidGenerator struct{}
+
+func (uuidGenerator) Next() string {
+	return uuid.NewString()
+}
+
+// sequentialGenerator counts up from 1, which makes IDs predictable in
+// tests and demos. Stores move it past numeric IDs it didn't hand out,
+// whether a client chose them or an earlier process left them in a
+// database, so it never walks into a run of taken IDs.
+type sequentialGenerator struct {
+	n atomic.Int64
+}
+
+func (g *sequentialGenerator) Next() string {
+	return strconv.FormatInt(g.n.Add(1), 10)
+}
+
+// skipPast makes later IDs count on from id if it is a number the
+// sequence hasn't reached yet.
+func (g *sequentialGenerator) skipPast(id string) {
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return
+	}
+	for {
+		cur := g.n.Load()
+		if n <= cur || g.n.CompareAndSwap(cur, n) {
+			return
+		}
+	}
+}
+
+// idSkipper is implemented by generators that must hear about IDs stored
+// without them, because they would hand the same IDs out later.
+type idSkipper interface {
+	skipPast(id string)
+}
+
+// skipPast tells ids about id if it's an idSkipper.
+func skipPast(ids IDGenerator, id string) {
+	if s, ok := ids.(idSkipper); ok {
+		s.skipPast(id)
+	}
+}
+
+// The ID schemes ID_GENERATOR accepts.
+const (
+	idGeneratorUUID       = "uuid"
+	idGeneratorSequential = "sequential"
+)
+
+// newIDGenerator returns a fresh generator for scheme, one of the
+// idGenerator constants.
+func newIDGenerator(scheme string) IDGenerator {
+	if scheme == idGeneratorSequential {
+		return &sequentialGenerator{}
+	}
+	return uuidGenerator{}
+}
+
+// orUUID returns ids, or a uuidGenerator when ids is nil.
+func orUUID(ids IDGenerator) IDGenerator {
+	if ids == nil {
+		return uuidGenerator{}
+	}
+	return ids
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestSequentialGenerator(t *testing.T) {
+	ts := startServer(t, newMemoryStore(&sequentialGenerator{}, 0), testConfig())
+	for _, want := range []string{"1", "2", "3"} {
+		if got := ts.create(movieJSON("Heat")).ID; got != want {
+			t.Fatalf("ID = %q, want %q", got, want)
+		}
+	}
+
+	// A client-chosen number moves the sequence past it.
+	ts.create(`{"id":"7","isbn":"0306406152","title":"Ronin","director":{"firstName":"John","lastName":"Frankenheimer"}}`)
+	ts.create(`{"id":"five","isbn":"0306406152","title":"Ronin","director":{"firstName":"John","lastName":"Frankenheimer"}}`)
+	if got := ts.create(movieJSON("Heat")).ID; got != "8" {
+		t.Errorf("ID after client ID 7 = %q, want 8", got)
+	}
+}
+
+func TestSequentialGeneratorResumesAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "movies.db")
+	ctx := context.Background()
+
+	s, err := NewSQLiteStore(path, &sequentialGenerator{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// More than maxIDAttempts, so a restarted count couldn't skip them.
+	for i := 0; i < maxIDAttempts+1; i++ {
+		if _, err := s.Create(ctx, testMovie("", "Heat")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Directors().Create(context.Background(), Director{FirstName: "Michael", LastName: "Mann"}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s, err = NewSQLiteStore(path, &sequentialGenerator{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	movie, err := s.Create(ctx, testMovie("", "Heat"))
+	if err != nil {
+		t.Fatalf("create after restart: %v", err)
+	}
+	if movie.ID != "13" {
+		t.Errorf("ID after restart = %q, want 13", movie.ID)
+	}
+}
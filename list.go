@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultLimit and maxLimit are the page sizes used unless
+// DEFAULT_PAGE_SIZE and MAX_PAGE_SIZE say otherwise.
+const (
+	defaultLimit = 20
+	maxLimit     = 100
+)
+
+// movieList is the envelope GET /movies responds with. Data holds the
+// page of movies, trimmed to the requested fields when ?fields= is set.
+type movieList struct {
+	Data any `json:"data"`
+	listMeta
+}
+
+// listMeta is everything in a movieList but the data, split out so
+// writeMovieList can encode it on its own. NextCursor is only set when
+// paging by cursor and more movies follow.
+type listMeta struct {
+	Total      int    `json:"total"`
+	Limit      int    `json:"limit"`
+	Offset     int    `json:"offset"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// writeMovieList writes the movieList for page to w one movie at a time,
+// so only a single encoded movie is held in memory rather than the whole
+// response. ?pretty=true is for people reading in a terminal and goes
+// through newJSONEncoder instead, which indents but buffers.
+//
+// The status has been sent by the time a movie fails to encode, so the
+// response is aborted rather than ended early, which would hand the
+// client truncated JSON that looks complete.
+func writeMovieList(w http.ResponseWriter, r *http.Request, page []Movie, fields []string, meta listMeta) error {
+	if queryBool(r.URL.Query(), "pretty") {
+		return newJSONEncoder(w, r).Encode(movieList{Data: sparseMovies(page, fields), listMeta: meta})
+	}
+	tail, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, `{"data":[`); err != nil {
+		return err
+	}
+	for i, movie := range page {
+		var item any = movie
+		if fields != nil {
+			item = selectFields(movie, fields)
+		}
+		b, err := json.Marshal(item)
+		if err != nil {
+			slog.Error("encode movie list",
+				"request_id", requestIDFromContext(r.Context()),
+				"id", movie.ID,
+				"error", err,
+			)
+			panic(http.ErrAbortHandler)
+		}
+		if i > 0 {
+			b = append([]byte{','}, b...)
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	// tail is the metadata object; its opening brace becomes the comma
+	// after the data array.
+	tail[0] = ','
+	_, err = fmt.Fprintf(w, "]%s\n", tail)
+	return err
+}
+
+// parsePagination reads limit and offset from the query string, using def
+// when limit is absent and clamping it to max.
+func parsePagination(r *http.Request, def, max int) (limit, offset int, err error) {
+	limit, err = queryInt(r, "limit", def)
+	if err != nil {
+		return 0, 0, err
+	}
+	offset, err = queryInt(r, "offset", 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	if limit > max {
+		limit = max
+	}
+	return limit, offset, nil
+}
+
+// yearRange is the release years ?year_min= and ?year_max= ask for. A zero
+// bound is unset.
+type yearRange struct {
+	min, max int
+}
+
+// parseYearRange reads year_min and year_max, rejecting a range whose
+// minimum is past its maximum.
+func parseYearRange(r *http.Request) (yearRange, error) {
+	var years yearRange
+	var err error
+	if years.min, err = queryInt(r, "year_min", 0); err != nil {
+		return yearRange{}, err
+	}
+	if years.max, err = queryInt(r, "year_max", 0); err != nil {
+		return yearRange{}, err
+	}
+	if years.min != 0 && years.max != 0 && years.min > years.max {
+		return yearRange{}, errors.New("year_min must not be greater than year_max")
+	}
+	return years, nil
+}
+
+// queryInt parses a non-negative integer query parameter, returning def
+// when it's absent.
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer", name)
+	}
+	return n, nil
+}
+
+// queryBool reports whether the query parameter name is set to a true
+// value such as "true" or "1".
+func queryBool(query url.Values, name string) bool {
+	b, _ := strconv.ParseBool(query.Get(name))
+	return b
+}
+
+// paginate returns the page of movies selected by limit and offset, never
+// nil so it always encodes as a JSON array.
+func paginate(movies []Movie, limit, offset int) []Movie {
+	if offset >= len(movies) {
+		return []Movie{}
+	}
+	end := offset + limit
+	if end > len(movies) {
+		end = len(movies)
+	}
+	return movies[offset:end]
+}
+
+// cursorPage returns up to limit movies that sort by ID after the one
+// cursor names, along with the cursor for the following page, or "" when
+// this is the last one. An empty cursor starts from the beginning. Since
+// the cursor is an ID rather than a position, movies created or deleted
+// between requests don't shift later pages.
+func cursorPage(movies []Movie, cursor string, limit int) (page []Movie, next string, err error) {
+	after, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	sortMovies(movies, "id")
+	start := sort.Search(len(movies), func(i int) bool { return movies[i].ID > after })
+	page = paginate(movies, limit, start)
+	if start+len(page) < len(movies) && len(page) > 0 {
+		next = encodeCursor(page[len(page)-1].ID)
+	}
+	return page, next, nil
+}
+
+// encodeCursor makes the opaque cursor clients send back to continue after
+// the movie with the given ID.
+func encodeCursor(id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(id))
+}
+
+func decodeCursor(cursor string) (string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", errors.New("cursor is invalid")
+	}
+	return string(b), nil
+}
+
+// movieLess orders two movies by one field, keyed by the name used in the
+// sort query parameter.
+var movieLess = map[string]func(a, b Movie) bool{
+	"id":        func(a, b Movie) bool { return a.ID < b.ID },
+	"isbn":      func(a, b Movie) bool { return a.ISBN < b.ISBN },
+	"title":     func(a, b Movie) bool { return a.Title < b.Title },
+	"createdAt": func(a, b Movie) bool { return a.CreatedAt.Before(b.CreatedAt) },
+	"updatedAt": func(a, b Movie) bool { return a.UpdatedAt.Before(b.UpdatedAt) },
+}
+
+// sortMovies sorts movies in place by spec, a field name optionally
+// prefixed with "-" for descending order. An empty spec leaves the order
+// untouched.
+func sortMovies(movies []Movie, spec string) error {
+	if spec == "" {
+		return nil
+	}
+	field, desc := strings.CutPrefix(spec, "-")
+	less, ok := movieLess[field]
+	if !ok {
+		return fmt.Errorf("cannot sort by %q", field)
+	}
+	sort.SliceStable(movies, func(i, j int) bool {
+		if desc {
+			return less(movies[j], movies[i])
+		}
+		return less(movies[i], movies[j])
+	})
+	return nil
+}
+
+// filterByTitle keeps the movies whose title contains q, ignoring case.
+func filterByTitle(movies []Movie, q string) []Movie {
+	if q == "" {
+		return movies
+	}
+	q = strings.ToLower(q)
+	out := []Movie{}
+	for _, m := range movies {
+		if strings.Contains(strings.ToLower(m.Title), q) {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// searchMovies keeps the movies where any whitespace-separated token of
+// term appears in the title or either director name, ignoring case. Movies
+// whose whole title equals term come first, then other title hits, then
+// movies that only matched on their director.
+func searchMovies(movies []Movie, term string) []Movie {
+	tokens := strings.Fields(strings.ToLower(term))
+	if len(tokens) == 0 {
+		return movies
+	}
+	term = strings.Join(tokens, " ")
+	var ranked [3][]Movie
+	for _, m := range movies {
+		title := strings.ToLower(m.Title)
+		switch {
+		case title == term:
+			ranked[0] = append(ranked[0], m)
+		case containsAny(title, tokens):
+			ranked[1] = append(ranked[1], m)
+		case m.Director != nil && (containsAny(strings.ToLower(m.Director.FirstName), tokens) ||
+			containsAny(strings.ToLower(m.Director.LastName), tokens)):
+			ranked[2] = append(ranked[2], m)
+		}
+	}
+	out := []Movie{}
+	for _, rank := range ranked {
+		out = append(out, rank...)
+	}
+	return out
+}
+
+// containsAny reports whether s contains any of tokens.
+func containsAny(s string, tokens []string) bool {
+	for _, t := range tokens {
+		if strings.Contains(s, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterByGenre keeps the movies tagged with genre, ignoring case.
+func filterByGenre(movies []Movie, genre string) []Movie {
+	if genre == "" {
+		return movies
+	}
+	out := []Movie{}
+	for _, m := range movies {
+		for _, g := range m.Genres {
+			if strings.EqualFold(g, genre) {
+				out = append(out, m)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// filterByYear keeps the movies released within years. Once either bound
+// is set, movies without a year no longer match.
+func filterByYear(movies []Movie, years yearRange) []Movie {
+	if years.min == 0 && years.max == 0 {
+		return movies
+	}
+	out := []Movie{}
+	for _, m := range movies {
+		if m.Year == 0 || m.Year < years.min || (years.max != 0 && m.Year > years.max) {
+			continue
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// filterByDirector keeps the movies whose director's names equal first
+// and last, ignoring case. An empty name matches any director, but a
+// movie without a director never matches a non-empty one.
+func filterByDirector(movies []Movie, first, last string) []Movie {
+	if first == "" && last == "" {
+		return movies
+	}
+	out := []Movie{}
+	for _, m := range movies {
+		if m.Director == nil {
+			continue
+		}
+		if first != "" && !strings.EqualFold(m.Director.FirstName, first) {
+			continue
+		}
+		if last != "" && !strings.EqualFold(m.Director.LastName, last) {
+			continue
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// filterDeleted leaves out soft-deleted movies.
+func filterDeleted(movies []Movie) []Movie {
+	out := []Movie{}
+	for _, m := range movies {
+		if m.DeletedAt == nil {
+			out = append(out, m)
+		}
+	}
+	return out
+}
@@ -0,0 +1,510 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testMovieList is a movieList whose data is decoded as movies.
+type testMovieList struct {
+	Data []Movie `json:"data"`
+	listMeta
+}
+
+// list GETs /v1/movies with query and returns the decoded list.
+func (ts *testServer) list(query string) testMovieList {
+	ts.t.Helper()
+	resp := ts.do("GET", "/v1/movies"+query, "")
+	expectStatus(ts.t, resp, http.StatusOK)
+	return decodeBody[testMovieList](ts.t, resp)
+}
+
+// createMovies creates n movies titled "Movie 1" to "Movie n", in order.
+func (ts *testServer) createMovies(n int) []Movie {
+	ts.t.Helper()
+	movies := make([]Movie, n)
+	for i := range movies {
+		movies[i] = ts.create(movieJSON(fmt.Sprintf("Movie %d", i+1)))
+	}
+	return movies
+}
+
+// ids returns the IDs of movies, in order.
+func ids(movies []Movie) []string {
+	out := make([]string, len(movies))
+	for i, m := range movies {
+		out[i] = m.ID
+	}
+	return out
+}
+
+func TestPagination(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	movies := ts.createMovies(25)
+	tests := []struct {
+		query         string
+		limit, offset int
+		want          []Movie
+	}{
+		{"", defaultLimit, 0, movies[:20]},
+		{"?limit=10&offset=10", 10, 10, movies[10:20]},
+		{"?limit=10&offset=20", 10, 20, movies[20:]},
+		{"?offset=25", defaultLimit, 25, nil},
+		{"?offset=500", defaultLimit, 500, nil},
+		{"?limit=1000", maxLimit, 0, movies},
+	}
+	for _, tt := range tests {
+		list := ts.list(tt.query)
+		if fmt.Sprint(ids(list.Data)) != fmt.Sprint(ids(tt.want)) {
+			t.Errorf("%s: got %v, want %v", tt.query, ids(list.Data), ids(tt.want))
+		}
+		if list.Data == nil {
+			t.Errorf("%s: data is null, want an array", tt.query)
+		}
+		if list.Total != 25 || list.Limit != tt.limit || list.Offset != tt.offset {
+			t.Errorf("%s: total %d, limit %d, offset %d; want 25, %d, %d", tt.query, list.Total, list.Limit, list.Offset, tt.limit, tt.offset)
+		}
+	}
+	for _, query := range []string{"?limit=-1", "?limit=ten", "?offset=-5", "?offset=1.5"} {
+		expectStatus(t, ts.do("GET", "/v1/movies"+query, ""), http.StatusBadRequest)
+	}
+}
+
+func TestSortMovies(t *testing.T) {
+	day := func(n int) time.Time { return time.Date(2024, 1, n, 0, 0, 0, 0, time.UTC) }
+	movies := []Movie{
+		{ID: "b", ISBN: "9780306406157", Title: "Heat", CreatedAt: day(1), UpdatedAt: day(5)},
+		{ID: "c", ISBN: "0306406152", Title: "Alien", CreatedAt: day(3), UpdatedAt: day(4)},
+		{ID: "a", ISBN: "123456789X", Title: "Ronin", CreatedAt: day(2), UpdatedAt: day(6)},
+	}
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"", "[b c a]"},
+		{"id", "[a b c]"},
+		{"-id", "[c b a]"},
+		{"title", "[c b a]"},
+		{"-title", "[a b c]"},
+		{"isbn", "[c a b]"},
+		{"-isbn", "[b a c]"},
+		{"createdAt", "[b a c]"},
+		{"-createdAt", "[c a b]"},
+		{"updatedAt", "[c b a]"},
+		{"-updatedAt", "[a b c]"},
+	}
+	for _, tt := range tests {
+		got := append([]Movie(nil), movies...)
+		if err := sortMovies(got, tt.spec); err != nil {
+			t.Errorf("sortMovies(%q): %v", tt.spec, err)
+			continue
+		}
+		if fmt.Sprint(ids(got)) != tt.want {
+			t.Errorf("sortMovies(%q) = %v, want %s", tt.spec, ids(got), tt.want)
+		}
+	}
+	if err := sortMovies(movies, "director"); err == nil {
+		t.Error("sortMovies accepted an unknown field")
+	}
+}
+
+func TestListSort(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	ts.create(movieJSON("Ronin"))
+	ts.create(movieJSON("Alien"))
+	ts.create(movieJSON("Heat"))
+	var titles []string
+	for _, m := range ts.list("?sort=-title").Data {
+		titles = append(titles, m.Title)
+	}
+	if fmt.Sprint(titles) != "[Ronin Heat Alien]" {
+		t.Errorf("titles = %v", titles)
+	}
+
+	resp := ts.do("GET", "/v1/movies?sort=color", "")
+	expectStatus(t, resp, http.StatusBadRequest)
+	if got := decodeBody[errorBody](t, resp).Error.Message; got != `cannot sort by "color"` {
+		t.Errorf("message = %q", got)
+	}
+}
+
+func TestTitleFilter(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	ts.createMovies(3)
+	ts.create(movieJSON("Heat"))
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"?q=heat", []string{"Heat"}},
+		{"?q=MOVIE", []string{"Movie 1", "Movie 2", "Movie 3"}},
+		{"?q=movie&limit=2&offset=1", []string{"Movie 2", "Movie 3"}},
+		{"?q=alien", []string{}},
+	}
+	for _, tt := range tests {
+		list := ts.list(tt.query)
+		titles := []string{}
+		for _, m := range list.Data {
+			titles = append(titles, m.Title)
+		}
+		if fmt.Sprint(titles) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: titles %v, want %v", tt.query, titles, tt.want)
+		}
+		if list.Data == nil {
+			t.Errorf("%s: data is null, want an array", tt.query)
+		}
+	}
+	if total := ts.list("?q=movie&limit=1").Total; total != 3 {
+		t.Errorf("total with q = %d, want the 3 matches", total)
+	}
+}
+
+func TestEmptyListIsArray(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	for _, m := range ts.createMovies(2) {
+		expectStatus(t, ts.do("DELETE", "/v1/movies/"+m.ID, ""), http.StatusOK)
+	}
+	tests := []struct{ path, want string }{
+		{"/v1/movies", `{"data":[],"total":0,"limit":20,"offset":0}`},
+		{"/v1/movies/recent", `[]`},
+		{"/v1/movies/directors", `[]`},
+	}
+	for _, tt := range tests {
+		resp := ts.do("GET", tt.path, "")
+		expectStatus(t, resp, http.StatusOK)
+		if got := strings.TrimSpace(readBody(t, resp)); got != tt.want {
+			t.Errorf("GET %s = %s, want %s", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestDirectorFilters(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	for _, body := range []string{
+		`{"isbn":"0306406152","title":"Heat","director":{"firstName":"Michael","lastName":"Mann"}}`,
+		`{"isbn":"0306406152","title":"Ali","director":{"firstName":"Michael","lastName":"Mann"}}`,
+		`{"isbn":"0306406152","title":"Alien","director":{"firstName":"Ridley","lastName":"Scott"}}`,
+		`{"isbn":"0306406152","title":"Doubt","director":{"firstName":"John","lastName":"Patrick Shanley"}}`,
+		`{"isbn":"0306406152","title":"Grizzly Man","director":{"firstName":"Werner","lastName":"Herzog"}}`,
+		`{"isbn":"0306406152","title":"Collateral Damage","director":{"firstName":"Andrew","lastName":"Davis"}}`,
+		`{"isbn":"0306406152","title":"The Insider","director":{"firstName":"Michael","lastName":"Mann"}}`,
+		`{"isbn":"0306406152","title":"Thelma","director":{"firstName":"Ridley","lastName":"Mann"}}`,
+	} {
+		ts.create(body)
+	}
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"?director_first=michael", "[Heat Ali The Insider]"},
+		{"?director_last=MANN", "[Heat Ali The Insider Thelma]"},
+		{"?director_first=ridley&director_last=mann", "[Thelma]"},
+		// Names match whole, not as substrings.
+		{"?director_last=Man", "[]"},
+	}
+	for _, tt := range tests {
+		titles := []string{}
+		for _, m := range ts.list(tt.query).Data {
+			titles = append(titles, m.Title)
+		}
+		if fmt.Sprint(titles) != tt.want {
+			t.Errorf("%s: titles %v, want %s", tt.query, titles, tt.want)
+		}
+	}
+
+	// A movie without a director matches no director filter.
+	movies := []Movie{{Title: "Untitled"}, {Title: "Heat", Director: &Director{FirstName: "Michael", LastName: "Mann"}}}
+	if got := filterByDirector(movies, "michael", ""); len(got) != 1 || got[0].Title != "Heat" {
+		t.Errorf("filterByDirector with a nil director = %v", got)
+	}
+}
+
+func TestCountMovies(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	ts.createMovies(3)
+	ts.create(`{"isbn":"0306406152","title":"Heat","director":{"firstName":"Michael","lastName":"Mann"}}`)
+	ts.create(`{"isbn":"0306406152","title":"Movie X","director":{"firstName":"Michael","lastName":"Mann"}}`)
+	deleted := ts.create(movieJSON("Movie Y"))
+	ts.do("DELETE", "/v1/movies/"+deleted.ID, "")
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"", 5},
+		{"?q=movie", 4},
+		{"?director_last=mann", 2},
+		{"?q=movie&director_last=mann", 1},
+		{"?include_deleted=true", 6},
+	}
+	for _, tt := range tests {
+		resp := ts.do("GET", "/v1/movies/count"+tt.query, "")
+		expectStatus(t, resp, http.StatusOK)
+		if got := decodeBody[map[string]int](t, resp)["count"]; got != tt.want {
+			t.Errorf("count%s = %d, want %d", tt.query, got, tt.want)
+		}
+		if total := ts.list(tt.query).Total; total != tt.want {
+			t.Errorf("list%s total = %d, want it to match the count %d", tt.query, total, tt.want)
+		}
+	}
+}
+
+func TestSearchMovies(t *testing.T) {
+	d := func(first, last string) *Director { return &Director{FirstName: first, LastName: last} }
+	movies := []Movie{
+		{ID: "1", Title: "Heat Wave", Director: d("Ann", "Lee")},
+		{ID: "2", Title: "Ronin", Director: d("John", "Heater")},
+		{ID: "3", Title: "Heat", Director: d("Michael", "Mann")},
+		{ID: "4", Title: "Alien", Director: d("Ridley", "Scott")},
+		{ID: "5", Title: "Legacy"},
+	}
+	tests := []struct {
+		term string
+		want string
+	}{
+		// The exact title first, then other titles, then directors.
+		{"HEAT", "[3 1 2]"},
+		{"  heat ", "[3 1 2]"},
+		{"ridley", "[4]"},
+		{"scott mann", "[3 4]"},
+		{"alien legacy", "[4 5]"},
+		{"nothing", "[]"},
+		{"", "[1 2 3 4 5]"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprint(ids(searchMovies(movies, tt.term))); got != tt.want {
+			t.Errorf("searchMovies(%q) = %s, want %s", tt.term, got, tt.want)
+		}
+	}
+}
+
+func TestSearchRoute(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	ts.create(`{"isbn":"0306406152","title":"Thief","director":{"firstName":"Michael","lastName":"Mann"}}`)
+	ts.create(`{"isbn":"0306406152","title":"Mannequin","director":{"firstName":"Michael","lastName":"Mann"}}`)
+	ts.create(`{"isbn":"0306406152","title":"Ronin","director":{"firstName":"John","lastName":"Frankenheimer"}}`)
+	var titles []string
+	for _, m := range ts.list("?search=mann").Data {
+		titles = append(titles, m.Title)
+	}
+	if got := strings.Join(titles, ", "); got != "Mannequin, Thief" {
+		t.Errorf("search=mann = %s, want Mannequin, Thief", got)
+	}
+}
+
+func TestConfiguredPageSize(t *testing.T) {
+	cfg := testConfig()
+	cfg.DefaultPageSize = 3
+	cfg.MaxPageSize = 5
+	ts := newTestServer(t, cfg)
+	movies := ts.createMovies(8)
+	for _, tt := range []struct {
+		query string
+		limit int
+	}{
+		{"", 3},
+		{"?limit=4", 4},
+		{"?limit=50", 5},
+	} {
+		list := ts.list(tt.query)
+		if list.Limit != tt.limit || fmt.Sprint(ids(list.Data)) != fmt.Sprint(ids(movies[:tt.limit])) {
+			t.Errorf("%q: limit %d, movies %v; want the first %d", tt.query, list.Limit, ids(list.Data), tt.limit)
+		}
+	}
+
+	t.Setenv("DEFAULT_PAGE_SIZE", "30")
+	t.Setenv("MAX_PAGE_SIZE", "100")
+	if cfg, err := LoadConfig(); err != nil || cfg.DefaultPageSize != 30 || cfg.MaxPageSize != 100 {
+		t.Errorf("page sizes from the environment = %d, %d, %v", cfg.DefaultPageSize, cfg.MaxPageSize, err)
+	}
+	t.Setenv("MAX_PAGE_SIZE", "10")
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "MAX_PAGE_SIZE") {
+		t.Errorf("max below default: got %v, want an error", err)
+	}
+	// Only the size that didn't parse is reported.
+	t.Setenv("DEFAULT_PAGE_SIZE", "lots")
+	_, err := LoadConfig()
+	if err == nil || !strings.Contains(err.Error(), "DEFAULT_PAGE_SIZE must be") || strings.Contains(err.Error(), "must not be less than") {
+		t.Errorf("unparsable default: got %v, want only its own error", err)
+	}
+}
+
+func TestCursorPagination(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	movies := ts.createMovies(10)
+	byID := append([]Movie(nil), movies...)
+	sortMovies(byID, "id")
+
+	var seen []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > len(movies) {
+			t.Fatal("cursor pagination never ended")
+		}
+		list := ts.list("?limit=3&cursor=" + cursor)
+		seen = append(seen, ids(list.Data)...)
+		if pages == 1 {
+			// Deleting a movie already seen and one still ahead must not
+			// shift the pages.
+			ts.do("DELETE", "/v1/movies/"+byID[0].ID, "")
+			ts.do("DELETE", "/v1/movies/"+byID[8].ID, "")
+		}
+		if list.NextCursor == "" {
+			break
+		}
+		cursor = list.NextCursor
+	}
+	var want []string
+	for i, m := range byID {
+		if i != 8 {
+			want = append(want, m.ID)
+		}
+	}
+	if fmt.Sprint(seen) != fmt.Sprint(want) {
+		t.Errorf("paged through %v, want %v", seen, want)
+	}
+
+	for _, query := range []string{"?cursor=!!", "?cursor=&sort=title", "?cursor=&offset=3"} {
+		expectStatus(t, ts.do("GET", "/v1/movies"+query, ""), http.StatusBadRequest)
+	}
+}
+
+func TestWriteMovieListMatchesBatch(t *testing.T) {
+	movies := []Movie{testMovie("a", "Heat"), testMovie("b", "Ronin"), testMovie("c", "Alien")}
+	movies[1].Genres = []string{"action"}
+	for _, tt := range []struct {
+		name   string
+		page   []Movie
+		fields []string
+		meta   listMeta
+	}{
+		{"empty", []Movie{}, nil, listMeta{Limit: 20}},
+		{"one", movies[:1], nil, listMeta{Total: 1, Limit: 20}},
+		{"page", movies, nil, listMeta{Total: 9, Limit: 3, Offset: 3}},
+		{"fields", movies, []string{"id", "genres"}, listMeta{Total: 3, Limit: 20}},
+		{"cursor", movies[:2], nil, listMeta{Total: 3, Limit: 2, NextCursor: encodeCursor("b")}},
+	} {
+		rec := httptest.NewRecorder()
+		if err := writeMovieList(rec, httptest.NewRequest("GET", "/v1/movies", nil), tt.page, tt.fields, tt.meta); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		want, _ := json.Marshal(movieList{Data: sparseMovies(tt.page, tt.fields), listMeta: tt.meta})
+		if got := rec.Body.String(); got != string(want)+"\n" {
+			t.Errorf("%s: streamed\n%s\nwant\n%s", tt.name, got, want)
+		}
+	}
+}
+
+func TestGenres(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	heat := ts.create(`{"isbn":"0306406152","title":"Heat","director":{"firstName":"Michael","lastName":"Mann"},"genres":[" Crime","drama","crime"]}`)
+	if got := fmt.Sprint(heat.Genres); got != "[Crime drama]" {
+		t.Errorf("created genres = %s, want [Crime drama]", got)
+	}
+	ts.create(`{"isbn":"0306406152","title":"Alien","director":{"firstName":"Ridley","lastName":"Scott"},"genres":["horror","sci-fi"]}`)
+	ts.create(movieJSON("Untagged"))
+
+	for genre, want := range map[string]string{
+		"crime":  "[Heat]",
+		"DRAMA":  "[Heat]",
+		"sci-fi": "[Alien]",
+		"comedy": "[]",
+	} {
+		var titles []string
+		for _, m := range ts.list("?genre=" + genre).Data {
+			titles = append(titles, m.Title)
+		}
+		if got := fmt.Sprint(titles); got != want {
+			t.Errorf("genre=%s: %s, want %s", genre, got, want)
+		}
+	}
+	expectStatus(t, ts.do("POST", "/v1/movies", `{"isbn":"0306406152","title":"Heat","director":{"firstName":"Michael","lastName":"Mann"},"genres":["crime",""]}`), http.StatusUnprocessableEntity)
+}
+
+func TestYearRange(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	for _, m := range []struct {
+		title string
+		year  int
+	}{{"Thief", 1981}, {"Heat", 1995}, {"Collateral", 2004}, {"Undated", 0}} {
+		body := fmt.Sprintf(`{"isbn":"0306406152","title":%q,"director":{"firstName":"Michael","lastName":"Mann"}`, m.title)
+		if m.year != 0 {
+			body += fmt.Sprintf(`,"year":%d`, m.year)
+		}
+		ts.create(body + "}")
+	}
+	for query, want := range map[string]string{
+		"":                             "[Thief Heat Collateral Undated]",
+		"?year_min=1990":               "[Heat Collateral]",
+		"?year_max=1995":               "[Thief Heat]",
+		"?year_min=1981&year_max=1995": "[Thief Heat]",
+		"?year_min=1995&year_max=1995": "[Heat]",
+		"?year_min=2010":               "[]",
+	} {
+		var titles []string
+		for _, m := range ts.list(query).Data {
+			titles = append(titles, m.Title)
+		}
+		if got := fmt.Sprint(titles); got != want {
+			t.Errorf("%q: %s, want %s", query, got, want)
+		}
+	}
+	for _, query := range []string{"?year_min=2000&year_max=1990", "?year_min=abc", "?year_max=-5"} {
+		expectStatus(t, ts.do("GET", "/v1/movies"+query, ""), http.StatusBadRequest)
+	}
+
+	latest := time.Now().Year() + maxYearsAhead
+	for _, year := range []int{minYear - 1, latest + 1} {
+		resp := ts.do("POST", "/v1/movies", fmt.Sprintf(`{"isbn":"0306406152","title":"Heat","director":{"firstName":"Michael","lastName":"Mann"},"year":%d}`, year))
+		expectStatus(t, resp, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestRecentMovies(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	movies := ts.createMovies(maxRecentMovies + 5)
+	newest := make([]Movie, len(movies))
+	for i, m := range movies {
+		newest[len(movies)-1-i] = m
+	}
+	recent := func(query string) []Movie {
+		t.Helper()
+		resp := ts.do("GET", "/v1/movies/recent"+query, "")
+		expectStatus(t, resp, http.StatusOK)
+		return decodeBody[[]Movie](t, resp)
+	}
+
+	// Updating a movie doesn't make it recent; only creating does.
+	ts.do("PATCH", "/v1/movies/"+movies[0].ID, `{"title":"Renamed","version":1}`)
+	ts.do("DELETE", "/v1/movies/"+newest[0].ID, "")
+	newest = newest[1:]
+
+	tests := []struct {
+		query string
+		want  []Movie
+	}{
+		{"", newest[:defaultRecentMovies]},
+		{"?n=3", newest[:3]},
+		{"?n=0", nil},
+		{"?n=1000", newest[:maxRecentMovies]},
+	}
+	for _, tt := range tests {
+		got := recent(tt.query)
+		if !slices.Equal(ids(got), ids(tt.want)) {
+			t.Errorf("recent%s = %v, want %v", tt.query, ids(got), ids(tt.want))
+		}
+		for i := 1; i < len(got); i++ {
+			if got[i].CreatedAt.After(got[i-1].CreatedAt) {
+				t.Errorf("recent%s: %s created after %s, which precedes it", tt.query, got[i].ID, got[i-1].ID)
+			}
+		}
+	}
+	expectStatus(t, ts.do("GET", "/v1/movies/recent?n=-1", ""), http.StatusBadRequest)
+	expectStatus(t, ts.do("GET", "/v1/movies/recent?n=five", ""), http.StatusBadRequest)
+}
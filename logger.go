@@ -0,0 +1,22 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+)
+
+// The log formats LOG_FORMAT accepts.
+const (
+	logFormatJSON = "json"
+	logFormatText = "text"
+)
+
+// newLogger returns a logger writing to w in format, one of the logFormat
+// constants, that drops records below level.
+func newLogger(w io.Writer, level slog.Level, format string) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+	if format == logFormatText {
+		return slog.New(slog.NewTextHandler(w, opts))
+	}
+	return slog.New(slog.NewJSONHandler(w, opts))
+}
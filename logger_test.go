@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	levels := []slog.Level{slog.LevelDebug, slog.LevelInfo, slog.LevelWarn, slog.LevelError}
+	for _, format := range []string{logFormatJSON, logFormatText} {
+		for _, min := range levels {
+			var buf bytes.Buffer
+			logger := newLogger(&buf, min, format)
+			for _, level := range levels {
+				logger.Log(context.Background(), level, "msg")
+			}
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			var got []string
+			for _, line := range lines {
+				if format == logFormatJSON {
+					var rec map[string]any
+					if err := json.Unmarshal([]byte(line), &rec); err != nil {
+						t.Fatalf("%s/%s: line is not JSON: %s", format, min, line)
+					}
+					got = append(got, rec["level"].(string))
+					continue
+				}
+				fields := strings.Fields(line)
+				if len(fields) < 2 || !strings.HasPrefix(fields[1], "level=") {
+					t.Fatalf("%s/%s: line is not text: %s", format, min, line)
+				}
+				got = append(got, strings.TrimPrefix(fields[1], "level="))
+			}
+			var want []string
+			for _, level := range levels {
+				if level >= min {
+					want = append(want, level.String())
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Errorf("%s at %s logged %v, want %v", format, min, got, want)
+			}
+		}
+	}
+}
+
+func TestLoadConfigLogging(t *testing.T) {
+	cfg, err := LoadConfig()
+	if err != nil || cfg.LogLevel != slog.LevelInfo || cfg.LogFormat != logFormatJSON {
+		t.Fatalf("default logging = %v, %q, %v; want info, json", cfg.LogLevel, cfg.LogFormat, err)
+	}
+	t.Setenv("LOG_LEVEL", "warn")
+	t.Setenv("LOG_FORMAT", "text")
+	cfg, err = LoadConfig()
+	if err != nil || cfg.LogLevel != slog.LevelWarn || cfg.LogFormat != logFormatText {
+		t.Errorf("LOG_LEVEL=warn, LOG_FORMAT=text: got %v, %q, %v", cfg.LogLevel, cfg.LogFormat, err)
+	}
+}
@@ -1,137 +1,1188 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"io"
 	"log"
-	"math/rand"
+	"log/slog"
+	"math/rand/v2"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
 	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
 	"encoding/json"
+
 	"github.com/gorilla/mux"
 )
 
 
+// Movie is the resource the API manages. Director is required on every
+// write, but stored movies may predate that rule, so readers must still
+// allow for it being nil. Version starts at 1 and goes up with every
+// update; PUT and PATCH must send the version they last read.
 type Movie struct {
-	ID       string   `json:"id"`
-	ISBN     int      `json:"isbn"`
-	Title    string   `json:"title"`
-	Director *Director `json:"director"`
+	ID         string     `json:"id"`
+	ISBN       string     `json:"isbn"`
+	Title      string     `json:"title"`
+	Director   *Director  `json:"director"`
+	DirectorID string     `json:"directorId,omitempty"`
+	Genres     []string   `json:"genres,omitempty"`
+	Year       int        `json:"year,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	UpdatedAt  time.Time  `json:"updatedAt"`
+	DeletedAt  *time.Time `json:"deletedAt,omitempty"`
+	Version    int        `json:"version"`
 }
 
 
 type Director struct {
+	ID        string `json:"id,omitempty"`
 	FirstName string `json:"firstName"`
 	LastName  string `json:"lastName"`
 }
 
 
-var movies []Movie
+// shutdownTimeout is how long in-flight requests get to finish once the
+// server is asked to stop.
+const shutdownTimeout = 10 * time.Second
+
+
+// handler serves the movie routes on top of a MovieStore.
+type handler struct {
+	store       MovieStore
+	directors   DirectorStore
+	idempotency *idempotencyCache
+	titlePolicy string
+	pageSize    int
+	maxPageSize int
+	// deleteResponse is one of the deleteResponse* choices.
+	deleteResponse string
+	// basePath prefixes the URLs the handlers hand out, such as Location.
+	basePath string
+	audit    AuditLog
+	events   *eventBroker
+	webhooks *webhookDispatcher
+	// modified holds lastModified in Unix nanoseconds.
+	modified atomic.Int64
+}
 
 
-func getMovies(w http.ResponseWriter, r *http.Request) {
+func (h *handler) getMovies(w http.ResponseWriter, r *http.Request) {
     w.Header().Set("Content-Type", "application/json")
-    err := json.NewEncoder(w).Encode(movies)
+    format := negotiate(r.Header.Get("Accept"), "application/json", "text/csv")
+    if format == "" {
+        writeJSONError(w, http.StatusNotAcceptable, "Supported formats are application/json and text/csv")
+        return
+    }
+    limit, offset, err := parsePagination(r, h.pageSize, h.maxPageSize)
+    if err != nil {
+        writeJSONError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    fields, err := parseFields(r.URL.Query())
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        writeJSONError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    years, err := parseYearRange(r)
+    if err != nil {
+        writeJSONError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    if checkNotModified(w, r, h.lastModified()) {
         return
     }
+    query := r.URL.Query()
+    movies, err := h.filteredMovies(r.Context(), query, years)
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    var page []Movie
+    var next string
+    if query.Has("cursor") {
+        if query.Has("sort") || query.Has("offset") {
+            writeJSONError(w, http.StatusBadRequest, "cursor cannot be combined with sort or offset")
+            return
+        }
+        if page, next, err = cursorPage(movies, query.Get("cursor"), limit); err != nil {
+            writeJSONError(w, http.StatusBadRequest, err.Error())
+            return
+        }
+    } else {
+        if err := sortMovies(movies, query.Get("sort")); err != nil {
+            writeJSONError(w, http.StatusBadRequest, err.Error())
+            return
+        }
+        page = paginate(movies, limit, offset)
+    }
+    if format == "text/csv" {
+        w.Header().Set("X-Total-Count", strconv.Itoa(len(movies)))
+        if next != "" {
+            w.Header().Set("X-Next-Cursor", next)
+        }
+        if err := writeCSV(w, page); err != nil {
+            slog.Error("write csv", "error", err)
+        }
+        return
+    }
+    err = writeMovieList(w, r, page, fields, listMeta{
+        Total:      len(movies),
+        Limit:      limit,
+        Offset:     offset,
+        NextCursor: next,
+    })
+    if err != nil {
+        slog.Error("write movie list", "request_id", requestIDFromContext(r.Context()), "error", err)
+    }
+}
+
+
+// filteredMovies returns the movies matching the list filters in query and
+// years, ranked by relevance when search is set. Soft-deleted movies are
+// left out unless include_deleted is set.
+func (h *handler) filteredMovies(ctx context.Context, query url.Values, years yearRange) ([]Movie, error) {
+    movies, err := h.store.GetAll(ctx)
+    if err != nil {
+        return nil, err
+    }
+    if !queryBool(query, "include_deleted") {
+        movies = filterDeleted(movies)
+    }
+    movies, err = h.withDirectors(ctx, movies)
+    if err != nil {
+        return nil, err
+    }
+    movies = filterByTitle(movies, query.Get("q"))
+    movies = filterByDirector(movies, query.Get("director_first"), query.Get("director_last"))
+    movies = filterByGenre(movies, query.Get("genre"))
+    movies = filterByYear(movies, years)
+    return searchMovies(movies, query.Get("search")), nil
+}
+
+
+func (h *handler) countMovies(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+    years, err := parseYearRange(r)
+    if err != nil {
+        writeJSONError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    movies, err := h.filteredMovies(r.Context(), r.URL.Query(), years)
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    newJSONEncoder(w, r).Encode(map[string]int{"count": len(movies)})
 }
 
 
-func deleteMovie(w http.ResponseWriter, r *http.Request) {
+// What a successful DELETE /movies/{id} answers with: the deleted movie,
+// carrying its deletedAt, or 204 and no body.
+const (
+    deleteResponseMovie = "movie"
+    deleteResponseNone  = "none"
+)
+
+
+func (h *handler) deleteMovie(w http.ResponseWriter, r *http.Request) {
     w.Header().Set("Content-Type", "application/json")
-    params := mux.Vars(r)
-    id := params["id"]
-    for index, item := range movies {
-        if item.ID == id {
-            movies = append(movies[:index], movies[index+1:]...)
-            json.NewEncoder(w).Encode(movies)
+    id, ok := movieID(w, r)
+    if !ok {
+        return
+    }
+    current, ok, err := h.findMovie(r.Context(), id)
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    if !ok {
+        writeJSONError(w, http.StatusNotFound, "Movie not found")
+        return
+    }
+    shown, err := h.withDirector(r.Context(), current)
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    if !checkIfMatch(w, r, shown) {
+        return
+    }
+    before := current
+    now := time.Now().UTC()
+    current.DeletedAt = &now
+    deleted, ok, err := h.store.Update(r.Context(), id, current)
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    if !ok {
+        h.updateFailed(w, r, id)
+        return
+    }
+    h.record(r, auditDelete, id, &before, &deleted)
+    if h.deleteResponse == deleteResponseNone {
+        w.Header().Del("Content-Type")
+        w.WriteHeader(http.StatusNoContent)
+        return
+    }
+    deleted, err = h.withDirector(r.Context(), deleted)
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    json.NewEncoder(w).Encode(deleted)
+}
+
+
+// batchDeleteResult reports which IDs a batch delete removed.
+type batchDeleteResult struct {
+	Deleted  []string `json:"deleted"`
+	NotFound []string `json:"notFound"`
+}
+
+
+func (h *handler) batchDeleteMovies(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+    var body struct {
+        IDs []string `json:"ids"`
+    }
+    err := decodeJSON(r, &body)
+    if err != nil {
+        writeDecodeError(w, err)
+        return
+    }
+    // The IDs are looked up, and reported back, the way movieID reads
+    // one from a path, so an upper-case UUID still finds its movie.
+    for i, id := range body.IDs {
+        canonical, ok := canonicalID(id)
+        if !ok {
+            writeFieldErrors(w, []FieldError{{Field: "ids", Message: "ids must be UUIDs or up to 64 letters, digits, '-' or '_'"}})
             return
         }
+        body.IDs[i] = canonical
+    }
+    before := make(map[string]Movie, len(body.IDs))
+    for _, id := range body.IDs {
+        movie, ok, err := h.store.GetByID(r.Context(), id)
+        if err != nil {
+            writeStoreError(w, r, err)
+            return
+        }
+        if ok {
+            before[id] = movie
+        }
+    }
+    deleted, notFound, err := h.store.MarkDeleted(r.Context(), body.IDs, time.Now().UTC())
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    for _, id := range deleted {
+        movie := before[id]
+        // The movies are deleted by now, so a failed read only costs the
+        // audit entry its after state.
+        after, _, _ := h.store.GetByID(r.Context(), id)
+        h.record(r, auditDelete, id, &movie, &after)
     }
-    http.Error(w, "Movie not found", http.StatusNotFound)
+    json.NewEncoder(w).Encode(batchDeleteResult{Deleted: deleted, NotFound: notFound})
 }
 
 
-func getMovie(w http.ResponseWriter, r *http.Request) {
+func (h *handler) restoreMovie(w http.ResponseWriter, r *http.Request) {
     w.Header().Set("Content-Type", "application/json")
-    params := mux.Vars(r)
-    id := params["id"]
-    for _, item := range movies {
-        if item.ID == id {
-            json.NewEncoder(w).Encode(item)
+    id, ok := movieID(w, r)
+    if !ok {
+        return
+    }
+    movie, ok, err := h.store.GetByID(r.Context(), id)
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    if !ok {
+        writeJSONError(w, http.StatusNotFound, "Movie not found")
+        return
+    }
+    if movie.DeletedAt != nil {
+        before := movie
+        movie.DeletedAt = nil
+        movie, ok, err = h.store.Update(r.Context(), id, movie)
+        if err != nil {
+            writeStoreError(w, r, err)
+            return
+        }
+        if !ok {
+            h.updateFailed(w, r, id)
             return
         }
+        h.record(r, auditRestore, id, &before, &movie)
+    }
+    movie, err = h.withDirector(r.Context(), movie)
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
     }
-    http.Error(w, "Movie not found", http.StatusNotFound)
+    json.NewEncoder(w).Encode(movie)
 }
 
 
-func createMovie(w http.ResponseWriter, r *http.Request) {
+// copySuffix is appended to the title of a movie made by duplicateMovie.
+const copySuffix = " (Copy)"
+
+
+// duplicateMovie creates a new movie from a live one, under a fresh ID and
+// with copySuffix appended to the title. The copy is validated and
+// checked against the duplicate title policy like any created movie.
+func (h *handler) duplicateMovie(w http.ResponseWriter, r *http.Request) {
     w.Header().Set("Content-Type", "application/json")
-    var movie Movie
-    err := json.NewDecoder(r.Body).Decode(&movie)
+    id, ok := movieID(w, r)
+    if !ok {
+        return
+    }
+    movie, ok, err := h.findMovie(r.Context(), id)
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    if !ok {
+        writeJSONError(w, http.StatusNotFound, "Movie not found")
+        return
+    }
+    movie.ID = ""
+    movie.Title += copySuffix
+    errs, err := h.checkMovie(r.Context(), &movie)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusBadRequest)
+        writeStoreError(w, r, err)
         return
     }
-    movie.ID = strconv.Itoa(rand.Intn(1000000)) 
-    movies = append(movies, movie)
+    if errs != nil {
+        writeFieldErrors(w, errs)
+        return
+    }
+    dups, err := h.duplicateTitles(r.Context(), []Movie{movie})
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    if dups != nil {
+        if h.titlePolicy == duplicateTitleReject {
+            writeFieldErrors(w, []FieldError{{Field: "title", Message: "a movie with this title already exists"}})
+            return
+        }
+        w.Header().Set("Warning", duplicateTitleWarning)
+    }
+    movie.CreatedAt = time.Now().UTC()
+    movie.UpdatedAt = movie.CreatedAt
+    movie.Version = 1
+    if isDryRun(r) {
+        if err := assignID(&movie.ID, dryRunIDs, h.existsIn(r.Context(), nil)); err != nil {
+            writeJSONError(w, http.StatusConflict, err.Error())
+            return
+        }
+        writeDryRun(w, movie)
+        return
+    }
+    movie, err = h.store.Create(r.Context(), movie)
+    if errors.Is(err, ErrStoreFull) {
+        writeJSONError(w, http.StatusInsufficientStorage, err.Error())
+        return
+    }
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    h.record(r, auditCreate, movie.ID, nil, &movie)
+    movie, err = h.withDirector(r.Context(), movie)
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    w.Header().Set("Location", h.basePath+"/v1/movies/"+movie.ID)
+    w.WriteHeader(http.StatusCreated)
     json.NewEncoder(w).Encode(movie)
 }
 
 
-func updateMovie(w http.ResponseWriter, r *http.Request) {
+// updateFailed answers a store Update that changed nothing: 409 if the
+// movie is still there, since its version must have moved on, and 404 if
+// it has gone.
+func (h *handler) updateFailed(w http.ResponseWriter, r *http.Request, id string) {
+    _, ok, err := h.store.GetByID(r.Context(), id)
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    if ok {
+        writeJSONError(w, http.StatusConflict, ErrVersionConflict.Error())
+        return
+    }
+    writeJSONError(w, http.StatusNotFound, "Movie not found")
+}
+
+
+// findMovie looks up a movie that hasn't been soft-deleted.
+func (h *handler) findMovie(ctx context.Context, id string) (Movie, bool, error) {
+    movie, ok, err := h.store.GetByID(ctx, id)
+    if err != nil || !ok || movie.DeletedAt != nil {
+        return Movie{}, false, err
+    }
+    return movie, true, nil
+}
+
+
+// liveMovies returns the movies that haven't been soft-deleted.
+func (h *handler) liveMovies(ctx context.Context) ([]Movie, error) {
+    movies, err := h.store.GetAll(ctx)
+    if err != nil {
+        return nil, err
+    }
+    return filterDeleted(movies), nil
+}
+
+
+// randomMovie returns one live movie chosen uniformly at random.
+func (h *handler) randomMovie(w http.ResponseWriter, r *http.Request) {
     w.Header().Set("Content-Type", "application/json")
-    params := mux.Vars(r)
-    id := params["id"]
-    for i, item := range movies {
-        if item.ID == id {
-            movies = append(movies[:i], movies[i+1:]...)
-            var movie Movie
-            err := json.NewDecoder(r.Body).Decode(&movie)
+    movies, err := h.liveMovies(r.Context())
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    if len(movies) == 0 {
+        writeJSONError(w, http.StatusNotFound, "No movies")
+        return
+    }
+    // math/rand/v2 seeds itself randomly, unlike the unseeded math/rand
+    // the original ID generator used.
+    movie, err := h.withDirector(r.Context(), movies[rand.IntN(len(movies))])
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    newJSONEncoder(w, r).Encode(movie)
+}
+
+
+// How many movies GET /movies/recent returns when n is left out, and at
+// most.
+const (
+    defaultRecentMovies = 10
+    maxRecentMovies     = 50
+)
+
+
+// recentMovies returns the n most recently created live movies, newest
+// first, for "recently added" lists.
+func (h *handler) recentMovies(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+    n, err := queryInt(r, "n", defaultRecentMovies)
+    if err != nil {
+        writeJSONError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    movies, err := h.liveMovies(r.Context())
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    movies, err = h.withDirectors(r.Context(), movies)
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    sortMovies(movies, "-createdAt")
+    newJSONEncoder(w, r).Encode(paginate(movies, min(n, maxRecentMovies), 0))
+}
+
+
+// movieExists answers 204 if the movie exists and 404 if it doesn't,
+// without a body either way.
+func (h *handler) movieExists(w http.ResponseWriter, r *http.Request) {
+    id, ok := movieID(w, r)
+    if !ok {
+        return
+    }
+    _, ok, err := h.findMovie(r.Context(), id)
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    if !ok {
+        w.WriteHeader(http.StatusNotFound)
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
+
+
+func (h *handler) getMovie(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+    id, ok := movieID(w, r)
+    if !ok {
+        return
+    }
+    fields, err := parseFields(r.URL.Query())
+    if err != nil {
+        writeJSONError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    movie, ok, err := h.store.GetByID(r.Context(), id)
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    if !ok || (movie.DeletedAt != nil && !queryBool(r.URL.Query(), "include_deleted")) {
+        writeJSONError(w, http.StatusNotFound, "Movie not found")
+        return
+    }
+    movie, err = h.withDirector(r.Context(), movie)
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    etag := movieETag(movie)
+    w.Header().Set("ETag", etag)
+    if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, etag) {
+        w.WriteHeader(http.StatusNotModified)
+        return
+    }
+    if fields != nil {
+        newJSONEncoder(w, r).Encode(selectFields(movie, fields))
+        return
+    }
+    newJSONEncoder(w, r).Encode(movie)
+}
+
+
+// getMovieByISBN returns the live movie with the ISBN in the path, which
+// may be written with or without hyphens. ISBNs are meant to be unique; if
+// several movies share one it returns the first and logs the duplicates.
+func (h *handler) getMovieByISBN(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+    isbn := normalizeISBN(mux.Vars(r)["isbn"])
+    if !isValidISBN(isbn) {
+        writeJSONError(w, http.StatusBadRequest, "Invalid ISBN")
+        return
+    }
+    movies, err := h.liveMovies(r.Context())
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    var matches []string
+    var movie Movie
+    for _, item := range movies {
+        if item.ISBN == isbn {
+            if matches == nil {
+                movie = item
+            }
+            matches = append(matches, item.ID)
+        }
+    }
+    if matches == nil {
+        writeJSONError(w, http.StatusNotFound, "Movie not found")
+        return
+    }
+    if len(matches) > 1 {
+        slog.Warn("several movies share an ISBN",
+            "request_id", requestIDFromContext(r.Context()),
+            "isbn", isbn,
+            "ids", matches,
+        )
+    }
+    movie, err = h.withDirector(r.Context(), movie)
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    w.Header().Set("ETag", movieETag(movie))
+    newJSONEncoder(w, r).Encode(movie)
+}
+
+
+func (h *handler) createMovie(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+    var movie Movie
+    err := decodeJSONWithSchema(r, movieSchema, &movie)
+    if err != nil {
+        writeDecodeError(w, err)
+        return
+    }
+    // A retry is answered before any of the checks below, which could now
+    // turn down the very movie its first attempt created.
+    key := r.Header.Get("Idempotency-Key")
+    if key != "" {
+        if created, ok := h.idempotency.get(r.Header.Get("X-API-Key"), key); ok {
+            created, err := h.withDirector(r.Context(), created)
             if err != nil {
-                http.Error(w, err.Error(), http.StatusBadRequest)
+                writeStoreError(w, r, err)
                 return
             }
-            movie.ID = id 
-            movies = append(movies, movie)
-            json.NewEncoder(w).Encode(movie)
+            w.Header().Set("Location", h.basePath+"/v1/movies/"+created.ID)
+            json.NewEncoder(w).Encode(created)
             return
         }
     }
-    http.Error(w, "Movie not found", http.StatusNotFound)
+    errs, err := h.checkMovie(r.Context(), &movie)
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    if errs != nil {
+        writeFieldErrors(w, errs)
+        return
+    }
+    dups, err := h.duplicateTitles(r.Context(), []Movie{movie})
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    if dups != nil {
+        if h.titlePolicy == duplicateTitleReject {
+            writeFieldErrors(w, []FieldError{{Field: "title", Message: "a movie with this title already exists"}})
+            return
+        }
+        w.Header().Set("Warning", duplicateTitleWarning)
+    }
+    movie.CreatedAt = time.Now().UTC()
+    movie.UpdatedAt = movie.CreatedAt
+    movie.Version = 1
+    if isDryRun(r) {
+        if err := assignID(&movie.ID, dryRunIDs, h.existsIn(r.Context(), nil)); err != nil {
+            writeJSONError(w, http.StatusConflict, err.Error())
+            return
+        }
+        writeDryRun(w, movie)
+        return
+    }
+    replayed := false
+    if key != "" {
+        movie, replayed, err = h.idempotency.do(r.Header.Get("X-API-Key"), key, func() (Movie, error) {
+            return h.store.Create(r.Context(), movie)
+        })
+    } else {
+        movie, err = h.store.Create(r.Context(), movie)
+    }
+    if errors.Is(err, ErrDuplicateID) {
+        writeJSONError(w, http.StatusConflict, err.Error())
+        return
+    }
+    if errors.Is(err, ErrStoreFull) {
+        writeJSONError(w, http.StatusInsufficientStorage, err.Error())
+        return
+    }
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    if !replayed {
+        h.record(r, auditCreate, movie.ID, nil, &movie)
+    }
+    movie, err = h.withDirector(r.Context(), movie)
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    w.Header().Set("Location", h.basePath+"/v1/movies/"+movie.ID)
+    if !replayed {
+        w.WriteHeader(http.StatusCreated)
+    }
+    json.NewEncoder(w).Encode(movie)
 }
 
 
+func (h *handler) createMovies(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+    var movies []Movie
+    err := decodeJSONWithSchema(r, movieListSchema, &movies)
+    if err != nil {
+        writeDecodeError(w, err)
+        return
+    }
+    dups, err := h.duplicateTitles(r.Context(), movies)
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    rejectTitle := make(map[int]bool)
+    if dups != nil {
+        if h.titlePolicy == duplicateTitleReject {
+            for _, i := range dups {
+                rejectTitle[i] = true
+            }
+        } else {
+            w.Header().Set("Warning", duplicateTitleWarning)
+        }
+    }
+    var invalid []indexedFieldErrors
+    for i := range movies {
+        errs, err := h.checkMovie(r.Context(), &movies[i])
+        if err != nil {
+            writeStoreError(w, r, err)
+            return
+        }
+        if rejectTitle[i] {
+            errs = append(errs, FieldError{Field: "title", Message: "a movie with this title already exists"})
+        }
+        if errs != nil {
+            invalid = append(invalid, indexedFieldErrors{Index: i, Errors: errs})
+        }
+    }
+    if invalid != nil {
+        w.WriteHeader(http.StatusUnprocessableEntity)
+        json.NewEncoder(w).Encode(invalid)
+        return
+    }
+    now := time.Now().UTC()
+    for i := range movies {
+        movies[i].CreatedAt = now
+        movies[i].UpdatedAt = now
+        movies[i].Version = 1
+    }
+    if isDryRun(r) {
+        taken := make(map[string]bool, len(movies))
+        for i := range movies {
+            if err := assignID(&movies[i].ID, dryRunIDs, h.existsIn(r.Context(), taken)); err != nil {
+                writeJSONError(w, http.StatusConflict, err.Error())
+                return
+            }
+            taken[movies[i].ID] = true
+        }
+        writeDryRun(w, movies)
+        return
+    }
+    movies, err = h.store.CreateMany(r.Context(), movies)
+    if errors.Is(err, ErrDuplicateID) {
+        writeJSONError(w, http.StatusConflict, err.Error())
+        return
+    }
+    if errors.Is(err, ErrStoreFull) {
+        writeJSONError(w, http.StatusInsufficientStorage, err.Error())
+        return
+    }
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    for i := range movies {
+        h.record(r, auditCreate, movies[i].ID, nil, &movies[i])
+    }
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(movies)
+}
 
-func main(){
-	r := mux.NewRouter()
 
-	movies = append(movies, Movie{
-		ID:       "1",
-		ISBN:     438227,
-		Title:    "Movie 1",
-		Director: &Director{FirstName: "John", LastName: "Doe"},
-	}, Movie{
-		ID:       "2",
-		ISBN:     45445,
-		Title:    "Movie 2",
-		Director: &Director{FirstName: "Steve", LastName: "Smith"},
-	})
+func (h *handler) updateMovie(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+    id, ok := movieID(w, r)
+    if !ok {
+        return
+    }
+    var movie Movie
+    err := decodeJSONWithSchema(r, movieSchema, &movie)
+    if err != nil {
+        writeDecodeError(w, err)
+        return
+    }
+    // A PUT to an ID that isn't taken creates the movie there.
+    current, exists, err := h.findMovie(r.Context(), id)
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    if exists {
+        shown, err := h.withDirector(r.Context(), current)
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    if !checkIfMatch(w, r, shown) {
+            return
+        }
+        if movie.Version == 0 {
+            writeFieldErrors(w, []FieldError{{Field: "version", Message: "version is required when replacing a movie"}})
+            return
+        }
+    } else if r.Header.Get("If-Match") != "" {
+        writeJSONError(w, http.StatusPreconditionFailed, "Movie does not exist")
+        return
+    }
+    errs, err := h.checkMovie(r.Context(), &movie)
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    if errs != nil {
+        writeFieldErrors(w, errs)
+        return
+    }
+    movie.CreatedAt = time.Now().UTC()
+    movie.UpdatedAt = movie.CreatedAt
+    if isDryRun(r) {
+        movie.ID = id
+        if !exists {
+            movie.Version = 1
+        } else if movie.Version != current.Version {
+            writeJSONError(w, http.StatusConflict, ErrVersionConflict.Error())
+            return
+        } else {
+            movie.CreatedAt = current.CreatedAt
+            movie.Version++
+        }
+        writeDryRun(w, newMovieUpdate(current, movie))
+        return
+    }
+    movie, created, err := h.store.Put(r.Context(), id, movie)
+    if errors.Is(err, ErrVersionConflict) {
+        writeJSONError(w, http.StatusConflict, err.Error())
+        return
+    }
+    if errors.Is(err, ErrStoreFull) {
+        writeJSONError(w, http.StatusInsufficientStorage, err.Error())
+        return
+    }
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    if created {
+        current = Movie{}
+        h.record(r, auditCreate, id, nil, &movie)
+    } else {
+        h.record(r, auditUpdate, id, &current, &movie)
+    }
+    w.Header().Set("ETag", movieETag(movie))
+    if created {
+        w.Header().Set("Location", h.basePath+"/v1/movies/"+movie.ID)
+        w.WriteHeader(http.StatusCreated)
+    }
+    json.NewEncoder(w).Encode(newMovieUpdate(current, movie))
+}
+
+
+// moviePatch is the body of a PATCH request; only the fields that are
+// present get applied to the stored movie.
+type moviePatch struct {
+	ISBN       *string   `json:"isbn"`
+	Title      *string   `json:"title"`
+	Director   *Director `json:"director"`
+	DirectorID *string   `json:"directorId"`
+	Genres     *[]string `json:"genres"`
+	Year       *int      `json:"year"`
+	Version    *int      `json:"version"`
+}
+
+
+func (p moviePatch) apply(movie Movie) Movie {
+	if p.ISBN != nil {
+		movie.ISBN = *p.ISBN
+	}
+	if p.Title != nil {
+		movie.Title = *p.Title
+	}
+	if p.Director != nil {
+		// An inline director replaces any linked one.
+		movie.Director = p.Director
+		movie.DirectorID = ""
+	}
+	if p.DirectorID != nil {
+		movie.DirectorID = *p.DirectorID
+	}
+	if p.Genres != nil {
+		movie.Genres = *p.Genres
+	}
+	if p.Year != nil {
+		movie.Year = *p.Year
+	}
+	if p.Version != nil {
+		movie.Version = *p.Version
+	}
+	return movie
+}
+
+
+func (h *handler) patchMovie(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+    id, ok := movieID(w, r)
+    if !ok {
+        return
+    }
+    var patch moviePatch
+    err := decodeJSON(r, &patch)
+    if err != nil {
+        writeDecodeError(w, err)
+        return
+    }
+    if patch.Version == nil {
+        writeFieldErrors(w, []FieldError{{Field: "version", Message: "version is required"}})
+        return
+    }
+    current, ok, err := h.findMovie(r.Context(), id)
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    if !ok {
+        writeJSONError(w, http.StatusNotFound, "Movie not found")
+        return
+    }
+    shown, err := h.withDirector(r.Context(), current)
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    if !checkIfMatch(w, r, shown) {
+        return
+    }
+    movie := patch.apply(current)
+    errs, err := h.checkMovie(r.Context(), &movie)
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    if errs != nil {
+        writeFieldErrors(w, errs)
+        return
+    }
+    movie.UpdatedAt = time.Now().UTC()
+    if isDryRun(r) {
+        if movie.Version != current.Version {
+            writeJSONError(w, http.StatusConflict, ErrVersionConflict.Error())
+            return
+        }
+        movie.Version++
+        writeDryRun(w, newMovieUpdate(current, movie))
+        return
+    }
+    movie, ok, err = h.store.Update(r.Context(), id, movie)
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    if !ok {
+        h.updateFailed(w, r, id)
+        return
+    }
+    h.record(r, auditUpdate, id, &current, &movie)
+    w.Header().Set("ETag", movieETag(movie))
+    json.NewEncoder(w).Encode(newMovieUpdate(current, movie))
+}
+
+
+// updateMovieDirector replaces just the director of a movie with the
+// inline director in the body, unlinking any director resource. The body
+// has no room for a version, so it updates whichever version it read and
+// relies on If-Match for clients that need to know nothing else changed.
+func (h *handler) updateMovieDirector(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+    id, ok := movieID(w, r)
+    if !ok {
+        return
+    }
+    var director Director
+    err := decodeJSON(r, &director)
+    if err != nil {
+        writeDecodeError(w, err)
+        return
+    }
+    errs := validateDirector(director)
+    if director.ID != "" {
+        errs = append(errs, FieldError{Field: "id", Message: "set directorId with PATCH to link a director"})
+    }
+    if errs != nil {
+        writeFieldErrors(w, errs)
+        return
+    }
+    current, ok, err := h.findMovie(r.Context(), id)
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    if !ok {
+        writeJSONError(w, http.StatusNotFound, "Movie not found")
+        return
+    }
+    shown, err := h.withDirector(r.Context(), current)
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    if !checkIfMatch(w, r, shown) {
+        return
+    }
+    movie := current
+    movie.Director = &director
+    movie.DirectorID = ""
+    movie.UpdatedAt = time.Now().UTC()
+    if isDryRun(r) {
+        movie.Version++
+        writeDryRun(w, movie)
+        return
+    }
+    movie, ok, err = h.store.Update(r.Context(), id, movie)
+    if err != nil {
+        writeStoreError(w, r, err)
+        return
+    }
+    if !ok {
+        h.updateFailed(w, r, id)
+        return
+    }
+    h.record(r, auditUpdate, id, &current, &movie)
+    w.Header().Set("ETag", movieETag(movie))
+    json.NewEncoder(w).Encode(movie)
+}
 
-	
-	r.HandleFunc("/movies",getMovies).Methods("GET")
-	r.HandleFunc("/movies/{id}",getMovie).Methods("GET")
-	r.HandleFunc("/movies",createMovie).Methods("POST")
-	r.HandleFunc("movies/{id}",updateMovie).Methods("PUT")
-	r.HandleFunc("movies/{id}",deleteMovie).Methods("DELETE")
 
-	fmt.Print("Starting server at port 8000\n")
-	log.Fatal(http.ListenAndServe(":8000",r))
+// openStore returns PostgreSQL stores when cfg.DatabaseURL is set, SQLite
+// stores when cfg.DBPath is, and falls back to empty in-memory stores
+// otherwise.
+func openStore(cfg Config) (MovieStore, DirectorStore, error) {
+	if cfg.DatabaseURL != "" {
+		s, err := NewPostgresStore(cfg.DatabaseURL, newIDGenerator(cfg.IDGenerator))
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, s.Directors(), nil
+	}
+	if path := cfg.DBPath; path != "" {
+		s, err := NewSQLiteStore(path, newIDGenerator(cfg.IDGenerator))
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, s.Directors(), nil
+	}
+	return newMemoryStore(newIDGenerator(cfg.IDGenerator), cfg.MaxMovies), newMemoryDirectorStore(newIDGenerator(cfg.IDGenerator)), nil
+}
 
-	
 
+// openAuditLog returns an audit log appending to cfg.AuditLogPath, or an
+// in-memory one when that is unset.
+func openAuditLog(cfg Config) (AuditLog, error) {
+	if cfg.AuditLogPath != "" {
+		return newFileAuditLog(cfg.AuditLogPath)
+	}
+	return newMemoryAuditLog(), nil
 }
 
 
+// seedMovies adds the two sample movies, skipping any that are already
+// there from an earlier run.
+func seedMovies(ctx context.Context, store MovieStore) {
+	now := time.Now().UTC()
+	samples := []Movie{{
+		ID:        "1",
+		ISBN:      "0306406152",
+		Title:     "Movie 1",
+		Director:  &Director{FirstName: "John", LastName: "Doe"},
+		CreatedAt: now,
+		UpdatedAt: now,
+		Version:   1,
+	}, {
+		ID:        "2",
+		ISBN:      "9780262033848",
+		Title:     "Movie 2",
+		Director:  &Director{FirstName: "Steve", LastName: "Smith"},
+		CreatedAt: now,
+		UpdatedAt: now,
+		Version:   1,
+	}}
+	for _, movie := range samples {
+		if _, err := store.Create(ctx, movie); err != nil && !errors.Is(err, ErrDuplicateID) {
+			log.Printf("seed movie %s: %v", movie.ID, err)
+		}
+	}
+}
+
+
+// logDraining logs how many requests shutdown is still waiting for, once
+// straight away and then every interval until done is closed.
+func logDraining(done <-chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		slog.Info("draining", "in_flight", inFlightRequests.Load())
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+
+func main(){
+	cfg, err := LoadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.SetDefault(newLogger(os.Stdout, cfg.LogLevel, cfg.LogFormat))
+	shutdownTracing, err := setupTracing(context.Background(), cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	store, directors, err := openStore(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.SeedData {
+		seedMovies(context.Background(), store)
+	}
+	audit, err := openAuditLog(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	server := NewServer(store, WithConfig(cfg), WithDirectorStore(directors), WithAuditLog(audit))
+
+	// Listening before serving means "server started" is only logged once
+	// connections can actually be accepted.
+	ln, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	started := time.Now()
+	slog.Info("server started", "addr", ln.Addr().String(), "config", cfg)
+	go func() {
+		var err error
+		if cfg.TLSCertFile != "" {
+			err = server.ServeTLS(ln, cfg.TLSCertFile, cfg.TLSKeyFile)
+		} else {
+			err = server.Serve(ln)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+
+	slog.Info("shutting down server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	drained := make(chan struct{})
+	go logDraining(drained, time.Second)
+	err = server.Shutdown(shutdownCtx)
+	close(drained)
+	if err != nil {
+		log.Fatalf("%v with %d requests still in flight", err, inFlightRequests.Load())
+	}
+	if c, ok := store.(io.Closer); ok {
+		c.Close()
+	}
+	if c, ok := audit.(io.Closer); ok {
+		c.Close()
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("flush spans", "error", err)
+	}
+	slog.Info("server stopped", "uptime", time.Since(started).Round(time.Millisecond).String())
+}
@@ -0,0 +1,1006 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+func TestMain(m *testing.M) {
+	// TestGracefulShutdown runs the test binary again as the real server.
+	if os.Getenv("CRUD_TEST_RUN_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
+	// Every request is logged; keep that out of the test output.
+	slog.SetDefault(slog.New(slog.NewJSONHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// testConfig is defaultConfig with a rate limit no test trips by accident.
+func testConfig() Config {
+	cfg := defaultConfig()
+	cfg.RateLimitRPS = 1e6
+	cfg.RateLimitBurst = 1e6
+	return cfg
+}
+
+// testServer is the API served over HTTP for the length of one test.
+type testServer struct {
+	*httptest.Server
+	t *testing.T
+}
+
+// newTestServer serves the API with cfg on an empty in-memory store.
+func newTestServer(t *testing.T, cfg Config, opts ...Option) *testServer {
+	t.Helper()
+	return startServer(t, newMemoryStore(nil, 0), cfg, opts...)
+}
+
+// startServer serves the API with cfg on store until the test ends.
+func startServer(t *testing.T, store MovieStore, cfg Config, opts ...Option) *testServer {
+	t.Helper()
+	srv := NewServer(store, append([]Option{WithConfig(cfg)}, opts...)...)
+	ts := httptest.NewServer(srv.Handler)
+	t.Cleanup(func() {
+		ts.Close()
+		// Runs the shutdown hooks, which stop the server's goroutines.
+		srv.Shutdown(context.Background())
+	})
+	return &testServer{Server: ts, t: t}
+}
+
+// do sends method to path with body, as JSON when it isn't empty, and
+// headers given as name, value pairs, which may override Content-Type.
+func (ts *testServer) do(method, path, body string, headers ...string) *http.Response {
+	ts.t.Helper()
+	var rd io.Reader
+	if body != "" {
+		rd = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, ts.URL+path, rd)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	ts.t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// create POSTs body to /v1/movies and returns the created movie.
+func (ts *testServer) create(body string) Movie {
+	ts.t.Helper()
+	resp := ts.do("POST", "/v1/movies", body)
+	expectStatus(ts.t, resp, http.StatusCreated)
+	return decodeBody[Movie](ts.t, resp)
+}
+
+// movieJSON is a movie body with the given title.
+func movieJSON(title string) string {
+	return fmt.Sprintf(`{"isbn":"0306406152","title":%q,"director":{"firstName":"Christopher","lastName":"Nolan"}}`, title)
+}
+
+// readBody returns the whole response body.
+func readBody(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// decodeBody decodes the JSON response body into a T.
+func decodeBody[T any](t *testing.T, resp *http.Response) T {
+	t.Helper()
+	var v T
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		t.Fatalf("decode %s response: %v", resp.Request.URL.Path, err)
+	}
+	return v
+}
+
+// expectStatus fails the test, showing the body, unless resp has status.
+func expectStatus(t *testing.T, resp *http.Response, status int) {
+	t.Helper()
+	if resp.StatusCode != status {
+		t.Fatalf("%s %s: got status %d, want %d: %s", resp.Request.Method, resp.Request.URL.Path, resp.StatusCode, status, readBody(t, resp))
+	}
+}
+
+func TestUpdateAndDeleteRoutes(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+
+	resp := ts.do("PUT", "/v1/movies/1", movieJSON("Memento"))
+	expectStatus(t, resp, http.StatusCreated)
+
+	resp = ts.do("PUT", "/v1/movies/1", `{"isbn":"0306406152","title":"Tenet","director":{"firstName":"Christopher","lastName":"Nolan"},"version":1}`)
+	expectStatus(t, resp, http.StatusOK)
+	if got := decodeBody[movieUpdate](t, resp).Movie.Title; got != "Tenet" {
+		t.Errorf("title after PUT = %q, want Tenet", got)
+	}
+
+	resp = ts.do("DELETE", "/v1/movies/1", "")
+	expectStatus(t, resp, http.StatusOK)
+	expectStatus(t, ts.do("GET", "/v1/movies/1", ""), http.StatusNotFound)
+	expectStatus(t, ts.do("DELETE", "/v1/movies/1", ""), http.StatusNotFound)
+}
+
+func TestCheckRoutes(t *testing.T) {
+	// newRouter panics if its own routes fail the check.
+	newRouter(&handler{}, newRateLimiter(1, 1), testConfig())
+
+	r := mux.NewRouter()
+	r.HandleFunc("/v1/movies", func(http.ResponseWriter, *http.Request) {})
+	r.HandleFunc("movies/{id}", func(http.ResponseWriter, *http.Request) {})
+	if err := checkRoutes(r); err == nil {
+		t.Error("checkRoutes accepted a path without a leading slash")
+	}
+}
+
+func TestConcurrentRequests(t *testing.T) {
+	// Run with -race: the point is that none of these overlap unsafely.
+	ts := newTestServer(t, testConfig())
+	const workers = 50
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := ts.Client().Post(ts.URL+"/v1/movies", "application/json", strings.NewReader(movieJSON("Heat")))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			var movie Movie
+			json.NewDecoder(resp.Body).Decode(&movie)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusCreated {
+				t.Errorf("create: status %d", resp.StatusCode)
+				return
+			}
+			for _, req := range []struct{ method, path string }{
+				{"GET", "/v1/movies/" + movie.ID},
+				{"GET", "/v1/movies"},
+				{"PUT", "/v1/movies/" + movie.ID},
+			} {
+				var body io.Reader
+				if req.method == "PUT" {
+					body = strings.NewReader(`{"isbn":"0306406152","title":"Ronin","director":{"firstName":"Christopher","lastName":"Nolan"},"version":1}`)
+				}
+				r, _ := http.NewRequest(req.method, ts.URL+req.path, body)
+				if body != nil {
+					r.Header.Set("Content-Type", "application/json")
+				}
+				resp, err := ts.Client().Do(r)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					t.Errorf("%s %s: status %d", req.method, req.path, resp.StatusCode)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	list := ts.list("?limit=100").Data
+	if len(list) != workers {
+		t.Fatalf("%d movies, want %d", len(list), workers)
+	}
+	for _, m := range list {
+		if m.Title != "Ronin" {
+			t.Errorf("movie %s title %q, want the update", m.ID, m.Title)
+		}
+	}
+}
+
+// recordingStore is a memory store that notes which methods were called.
+type recordingStore struct {
+	*memoryStore
+	mu    sync.Mutex
+	calls []string
+}
+
+func (s *recordingStore) record(method string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, method)
+}
+
+// took returns the calls recorded since the last one and forgets them.
+func (s *recordingStore) took() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	calls := s.calls
+	s.calls = nil
+	return calls
+}
+
+func (s *recordingStore) GetAll(ctx context.Context) ([]Movie, error) {
+	s.record("GetAll")
+	return s.memoryStore.GetAll(ctx)
+}
+
+func (s *recordingStore) GetByID(ctx context.Context, id string) (Movie, bool, error) {
+	s.record("GetByID")
+	return s.memoryStore.GetByID(ctx, id)
+}
+
+func (s *recordingStore) Create(ctx context.Context, movie Movie) (Movie, error) {
+	s.record("Create")
+	return s.memoryStore.Create(ctx, movie)
+}
+
+func (s *recordingStore) Update(ctx context.Context, id string, movie Movie) (Movie, bool, error) {
+	s.record("Update")
+	return s.memoryStore.Update(ctx, id, movie)
+}
+
+func (s *recordingStore) Put(ctx context.Context, id string, movie Movie) (Movie, bool, error) {
+	s.record("Put")
+	return s.memoryStore.Put(ctx, id, movie)
+}
+
+func (s *recordingStore) Delete(ctx context.Context, id string) (bool, error) {
+	s.record("Delete")
+	return s.memoryStore.Delete(ctx, id)
+}
+
+func TestHandlersUseStore(t *testing.T) {
+	store := &recordingStore{memoryStore: newMemoryStore(nil, 0)}
+	ts := startServer(t, store, testConfig())
+	id := ts.create(movieJSON("Heat")).ID
+	if got := store.took(); fmt.Sprint(got) != "[Create]" {
+		t.Errorf("POST /v1/movies called %v, want [Create]", got)
+	}
+	tests := []struct {
+		method, path, body string
+		calls              []string
+	}{
+		{"GET", "/v1/movies", "", []string{"GetAll"}},
+		{"GET", "/v1/movies/" + id, "", []string{"GetByID"}},
+		{"PUT", "/v1/movies/" + id, `{"isbn":"0306406152","title":"Ronin","director":{"firstName":"John","lastName":"Frankenheimer"},"version":1}`, []string{"GetByID", "Put"}},
+		{"DELETE", "/v1/movies/" + id, "", []string{"GetByID", "Update"}},
+	}
+	for _, tt := range tests {
+		resp := ts.do(tt.method, tt.path, tt.body)
+		expectStatus(t, resp, http.StatusOK)
+		if got := store.took(); fmt.Sprint(got) != fmt.Sprint(tt.calls) {
+			t.Errorf("%s %s called %v, want %v", tt.method, tt.path, got, tt.calls)
+		}
+	}
+}
+
+func TestPatchMovie(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	created := ts.create(movieJSON("Heat"))
+
+	resp := ts.do("PATCH", "/v1/movies/"+created.ID, `{"title":"Ronin","version":1}`)
+	expectStatus(t, resp, http.StatusOK)
+	patched := decodeBody[movieUpdate](t, resp).Movie
+	if patched.Title != "Ronin" || patched.ISBN != created.ISBN || *patched.Director != *created.Director {
+		t.Errorf("patched movie = %+v, want only the title changed from %+v", patched, created)
+	}
+	if patched.Version != 2 {
+		t.Errorf("version = %d, want 2", patched.Version)
+	}
+
+	resp = ts.do("PATCH", "/v1/movies/"+created.ID, `{"director":{"firstName":"John","lastName":"Frankenheimer"},"version":2}`)
+	expectStatus(t, resp, http.StatusOK)
+	if got := decodeBody[movieUpdate](t, resp).Movie; got.Title != "Ronin" || got.Director.LastName != "Frankenheimer" {
+		t.Errorf("after patching the director: %+v", got)
+	}
+
+	expectStatus(t, ts.do("PATCH", "/v1/movies/"+created.ID, `{"title":"","version":3}`), http.StatusUnprocessableEntity)
+	expectStatus(t, ts.do("PATCH", "/v1/movies/"+created.ID, `{"titel":"Thief","version":3}`), http.StatusBadRequest)
+	expectStatus(t, ts.do("PATCH", "/v1/movies/"+created.ID, `{"title":"Thief"}`), http.StatusUnprocessableEntity)
+	expectStatus(t, ts.do("PATCH", "/v1/movies/missing", `{"title":"Thief","version":1}`), http.StatusNotFound)
+
+	resp = ts.do("GET", "/v1/movies/"+created.ID, "")
+	if got := decodeBody[Movie](t, resp); got.Title != "Ronin" || got.Version != 3 {
+		t.Errorf("after rejected patches: %+v", got)
+	}
+}
+
+// mainProcess is the test binary run again as the real server, with main
+// reading its configuration from the environment.
+type mainProcess struct {
+	cmd  *exec.Cmd
+	port string
+	logs *bufio.Scanner
+	t    *testing.T
+	// started is the "server started" log line.
+	started string
+}
+
+// startMain runs main on a free port with env added to the environment and
+// waits until it logs that it has started. The process is killed when the
+// test ends if it hasn't exited by then.
+func startMain(t *testing.T, env ...string) *mainProcess {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := fmt.Sprint(ln.Addr().(*net.TCPAddr).Port)
+	ln.Close()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.Env = append(os.Environ(), "CRUD_TEST_RUN_MAIN=1", "PORT="+port)
+	cmd.Env = append(cmd.Env, env...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cmd.Process.Kill() })
+	p := &mainProcess{cmd: cmd, port: port, logs: bufio.NewScanner(stdout), t: t}
+	p.started = p.waitFor("server started")
+	return p
+}
+
+// waitFor reads the server's log until a line with message msg and
+// returns that line.
+func (p *mainProcess) waitFor(msg string) string {
+	p.t.Helper()
+	for p.logs.Scan() {
+		if strings.Contains(p.logs.Text(), `"msg":"`+msg+`"`) {
+			return p.logs.Text()
+		}
+	}
+	p.t.Fatalf("server exited without logging %q", msg)
+	return ""
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	p := startMain(t)
+	port := p.port
+
+	// A create whose body is still arriving when the signal comes in.
+	body, send := io.Pipe()
+	req, _ := http.NewRequest("POST", "http://127.0.0.1:"+port+"/v1/movies", body)
+	req.Header.Set("Content-Type", "application/json")
+	done := make(chan *http.Response)
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- resp
+	}()
+	full := movieJSON("Heat")
+	io.WriteString(send, full[:10])
+	time.Sleep(100 * time.Millisecond)
+	p.cmd.Process.Signal(syscall.SIGTERM)
+	// Draining reports the create it is waiting for.
+	if line := p.waitFor("draining"); !strings.Contains(line, `"in_flight":1`) {
+		t.Errorf("draining log = %s, want one request in flight", line)
+	}
+	io.WriteString(send, full[10:])
+	send.Close()
+
+	resp := <-done
+	if resp == nil {
+		t.Fatal("request in flight during shutdown failed")
+	}
+	defer resp.Body.Close()
+	expectStatus(t, resp, http.StatusCreated)
+	p.waitFor("server stopped")
+	if err := p.cmd.Wait(); err != nil {
+		t.Errorf("server exited with %v", err)
+	}
+}
+
+func TestLifecycleLogs(t *testing.T) {
+	p := startMain(t, "API_KEYS=secret-key", "READ_ONLY=true")
+	var started struct {
+		Level  string
+		Addr   string
+		Config map[string]any
+	}
+	if err := json.Unmarshal([]byte(p.started), &started); err != nil {
+		t.Fatalf("started log %s: %v", p.started, err)
+	}
+	if started.Level != "INFO" || !strings.HasSuffix(started.Addr, ":"+p.port) {
+		t.Errorf("started log = %s, want INFO on port %s", p.started, p.port)
+	}
+	if started.Config["store"] != "memory" || started.Config["read_only"] != true || started.Config["api_keys"] != 1.0 {
+		t.Errorf("started config = %v", started.Config)
+	}
+	if strings.Contains(p.started, "secret-key") {
+		t.Errorf("started log leaks the API key: %s", p.started)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	p.cmd.Process.Signal(syscall.SIGTERM)
+	if line := p.waitFor("shutting down server"); !strings.Contains(line, `"level":"INFO"`) {
+		t.Errorf("shutdown log = %s, want it at INFO", line)
+	}
+	line := p.waitFor("server stopped")
+	var stopped struct{ Uptime string }
+	if err := json.Unmarshal([]byte(line), &stopped); err != nil {
+		t.Fatalf("stopped log %s: %v", line, err)
+	}
+	if uptime, err := time.ParseDuration(stopped.Uptime); err != nil || uptime < 20*time.Millisecond {
+		t.Errorf("uptime = %q, want at least 20ms", stopped.Uptime)
+	}
+	if err := p.cmd.Wait(); err != nil {
+		t.Errorf("server exited with %v", err)
+	}
+}
+
+func TestBulkCreate(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	resp := ts.do("POST", "/v1/movies/bulk", "["+movieJSON("Heat")+","+movieJSON("Ronin")+"]")
+	expectStatus(t, resp, http.StatusCreated)
+	created := decodeBody[[]Movie](t, resp)
+	if len(created) != 2 || created[0].Title != "Heat" || created[1].Title != "Ronin" || created[0].ID == "" || created[0].ID == created[1].ID {
+		t.Fatalf("created = %+v", created)
+	}
+
+	resp = ts.do("POST", "/v1/movies/bulk", "["+movieJSON("Thief")+`,{"isbn":"0306406152","title":"","director":{"firstName":"Michael","lastName":"Mann"}}]`)
+	expectStatus(t, resp, http.StatusUnprocessableEntity)
+	invalid := decodeBody[[]indexedFieldErrors](t, resp)
+	if len(invalid) != 1 || invalid[0].Index != 1 || len(invalid[0].Errors) != 1 || invalid[0].Errors[0].Field != "title" {
+		t.Errorf("errors = %+v, want one title error at index 1", invalid)
+	}
+	if total := ts.list("").Total; total != 2 {
+		t.Errorf("total after rejected batch = %d, want 2", total)
+	}
+}
+
+func TestCreateMovieLocation(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	resp := ts.do("POST", "/v1/movies", movieJSON("Heat"))
+	expectStatus(t, resp, http.StatusCreated)
+	created := decodeBody[Movie](t, resp)
+	loc := resp.Header.Get("Location")
+	if loc != "/v1/movies/"+created.ID {
+		t.Fatalf("Location = %q, want /v1/movies/%s", loc, created.ID)
+	}
+	resp = ts.do("GET", loc, "")
+	expectStatus(t, resp, http.StatusOK)
+	if got := decodeBody[Movie](t, resp); got.ID != created.ID || got.Title != "Heat" {
+		t.Errorf("GET Location = %+v", got)
+	}
+}
+
+func TestMissingDirector(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore(nil, 0)
+	ts := startServer(t, store, testConfig())
+
+	resp := ts.do("POST", "/v1/movies", `{"isbn":"0306406152","title":"x"}`)
+	expectStatus(t, resp, http.StatusUnprocessableEntity)
+	if errs := decodeBody[[]FieldError](t, resp); len(errs) != 1 || errs[0].Field != "director" {
+		t.Errorf("errors = %v, want one for director", errs)
+	}
+
+	// Older data may lack a director; every read must cope with it.
+	movie := testMovie("legacy", "Heat")
+	movie.Director = nil
+	store.Create(ctx, movie)
+	for _, path := range []string{
+		"/v1/movies",
+		"/v1/movies?q=heat",
+		"/v1/movies?director_first=x",
+		"/v1/movies?sort=title",
+		"/v1/movies/legacy",
+	} {
+		expectStatus(t, ts.do("GET", path, ""), http.StatusOK)
+	}
+	expectStatus(t, ts.do("GET", "/v1/movies", "", "Accept", "text/csv"), http.StatusOK)
+}
+
+func TestSoftDelete(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	movies := ts.createMovies(2)
+	gone := movies[0].ID
+
+	expectStatus(t, ts.do("DELETE", "/v1/movies/"+gone, ""), http.StatusOK)
+	if got := ids(ts.list("").Data); len(got) != 1 || got[0] != movies[1].ID {
+		t.Errorf("list after delete = %v, want only %s", got, movies[1].ID)
+	}
+	expectStatus(t, ts.do("GET", "/v1/movies/"+gone, ""), http.StatusNotFound)
+
+	all := ts.list("?include_deleted=true").Data
+	if len(all) != 2 || all[0].ID != gone || all[0].DeletedAt == nil {
+		t.Errorf("include_deleted list = %+v, want the deleted movie first with deletedAt", all)
+	}
+	resp := ts.do("GET", "/v1/movies/"+gone+"?include_deleted=true", "")
+	expectStatus(t, resp, http.StatusOK)
+
+	resp = ts.do("POST", "/v1/movies/"+gone+"/restore", "")
+	expectStatus(t, resp, http.StatusOK)
+	if restored := decodeBody[Movie](t, resp); restored.DeletedAt != nil {
+		t.Errorf("restored movie still has deletedAt %v", restored.DeletedAt)
+	}
+	if got := ts.list("").Total; got != 2 {
+		t.Errorf("total after restore = %d, want 2", got)
+	}
+	expectStatus(t, ts.do("POST", "/v1/movies/missing/restore", ""), http.StatusNotFound)
+}
+
+func TestDeleteResponse(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	movies := ts.createMovies(3)
+	gone := movies[1]
+
+	resp := ts.do("DELETE", "/v1/movies/"+gone.ID, "")
+	expectStatus(t, resp, http.StatusOK)
+	// The body is the one deleted movie, not the movies that are left.
+	body := readBody(t, resp)
+	var deleted Movie
+	if err := json.Unmarshal([]byte(body), &deleted); err != nil {
+		t.Fatalf("DELETE body %s is not a movie: %v", body, err)
+	}
+	if deleted.ID != gone.ID || deleted.Title != gone.Title || deleted.DeletedAt == nil || deleted.Version != 2 {
+		t.Errorf("DELETE answered %+v, want %s with deletedAt", deleted, gone.ID)
+	}
+	for _, m := range []Movie{movies[0], movies[2]} {
+		if strings.Contains(body, m.ID) {
+			t.Errorf("DELETE body mentions remaining movie %s: %s", m.ID, body)
+		}
+	}
+
+	cfg := testConfig()
+	cfg.DeleteResponse = deleteResponseNone
+	ts = newTestServer(t, cfg)
+	movie := ts.create(movieJSON("Heat"))
+	resp = ts.do("DELETE", "/v1/movies/"+movie.ID, "")
+	expectStatus(t, resp, http.StatusNoContent)
+	if got := readBody(t, resp); got != "" {
+		t.Errorf("204 DELETE body = %q", got)
+	}
+	if got := resp.Header.Get("Content-Type"); got != "" {
+		t.Errorf("204 DELETE Content-Type = %q", got)
+	}
+	expectStatus(t, ts.do("GET", "/v1/movies/"+movie.ID, ""), http.StatusNotFound)
+	expectStatus(t, ts.do("DELETE", "/v1/movies/"+movie.ID, ""), http.StatusNotFound)
+}
+
+func TestTimestamps(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	before := time.Now().UTC()
+	resp := ts.do("POST", "/v1/movies", movieJSON("Heat"))
+	expectStatus(t, resp, http.StatusCreated)
+	var raw struct{ CreatedAt, UpdatedAt string }
+	if err := json.Unmarshal([]byte(readBody(t, resp)), &raw); err != nil {
+		t.Fatal(err)
+	}
+	created, err := time.Parse(time.RFC3339, raw.CreatedAt)
+	if err != nil {
+		t.Fatalf("createdAt %q is not RFC3339: %v", raw.CreatedAt, err)
+	}
+	if created.Before(before) || created.After(time.Now()) {
+		t.Errorf("createdAt %v is outside the request", created)
+	}
+	if raw.UpdatedAt != raw.CreatedAt {
+		t.Errorf("new movie's updatedAt %s != createdAt %s", raw.UpdatedAt, raw.CreatedAt)
+	}
+
+	id := ts.list("").Data[0].ID
+	last := created
+	for _, req := range []struct{ method, body string }{
+		{"PATCH", `{"title":"Ronin","version":1}`},
+		{"PUT", `{"isbn":"0306406152","title":"Tenet","director":{"firstName":"Christopher","lastName":"Nolan"},"version":2}`},
+	} {
+		time.Sleep(time.Millisecond)
+		resp := ts.do(req.method, "/v1/movies/"+id, req.body)
+		expectStatus(t, resp, http.StatusOK)
+		movie := decodeBody[movieUpdate](t, resp).Movie
+		if !movie.CreatedAt.Equal(created) {
+			t.Errorf("%s changed createdAt to %v, want %v", req.method, movie.CreatedAt, created)
+		}
+		if !movie.UpdatedAt.After(last) {
+			t.Errorf("%s: updatedAt %v did not move past %v", req.method, movie.UpdatedAt, last)
+		}
+		last = movie.UpdatedAt
+	}
+}
+
+func TestUpdateKeepsPosition(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	movies := ts.createMovies(3)
+	middle := movies[1]
+
+	resp := ts.do("PUT", "/v1/movies/"+middle.ID, `{"isbn":"0306406152","title":"Tenet","director":{"firstName":"Christopher","lastName":"Nolan"},"version":1}`)
+	expectStatus(t, resp, http.StatusOK)
+
+	list := ts.list("").Data
+	if fmt.Sprint(ids(list)) != fmt.Sprint(ids(movies)) {
+		t.Fatalf("order after update = %v, want %v", ids(list), ids(movies))
+	}
+	got := list[1]
+	if got.Title != "Tenet" || got.Version != 2 {
+		t.Errorf("updated movie = %+v, want Tenet at version 2", got)
+	}
+	if !got.CreatedAt.Equal(middle.CreatedAt) {
+		t.Errorf("createdAt = %v, want %v", got.CreatedAt, middle.CreatedAt)
+	}
+}
+
+func TestSeedMovies(t *testing.T) {
+	ctx := context.Background()
+	for _, tt := range []struct {
+		env  string
+		want bool
+	}{{"", false}, {"true", true}, {"false", false}} {
+		t.Setenv("SEED_DATA", tt.env)
+		if seed, err := envBool("SEED_DATA", false); err != nil || seed != tt.want {
+			t.Errorf("SEED_DATA=%q: seed %v, %v; want %v", tt.env, seed, err, tt.want)
+		}
+	}
+	t.Setenv("SEED_DATA", "yes please")
+	if _, err := envBool("SEED_DATA", false); err == nil {
+		t.Error("invalid SEED_DATA was accepted")
+	}
+
+	store := newMemoryStore(nil, 0)
+	seedMovies(ctx, store)
+	movies, _ := store.GetAll(ctx)
+	if len(movies) != 2 || movies[0].Title != "Movie 1" || movies[1].Title != "Movie 2" {
+		t.Fatalf("seeded movies = %+v, want Movie 1 and Movie 2", movies)
+	}
+
+	// A restart with the same data must not duplicate or overwrite it.
+	edited := movies[0]
+	edited.Title = "Heat"
+	store.Update(ctx, edited.ID, edited)
+	seedMovies(ctx, store)
+	movies, _ = store.GetAll(ctx)
+	if len(movies) != 2 || movies[0].Title != "Heat" {
+		t.Errorf("movies after reseeding = %+v, want the two, with the edit kept", movies)
+	}
+}
+
+func TestBatchDelete(t *testing.T) {
+	for name, store := range map[string]MovieStore{
+		"memory": newMemoryStore(nil, 0),
+		"sqlite": newTestSQLiteStore(t),
+	} {
+		t.Run(name, func(t *testing.T) {
+			ts := startServer(t, store, testConfig())
+			movies := ts.createMovies(4)
+			expectStatus(t, ts.do("DELETE", "/v1/movies/"+movies[3].ID, ""), http.StatusOK)
+
+			body := fmt.Sprintf(`{"ids":[%q,%q,"missing",%q]}`, movies[0].ID, movies[3].ID, movies[2].ID)
+			resp := ts.do("POST", "/v1/movies/batch-delete", body)
+			expectStatus(t, resp, http.StatusOK)
+			got := decodeBody[batchDeleteResult](t, resp)
+			if want := []string{movies[0].ID, movies[2].ID}; fmt.Sprint(got.Deleted) != fmt.Sprint(want) {
+				t.Errorf("deleted = %v, want %v", got.Deleted, want)
+			}
+			// Already deleted counts as not found.
+			if want := []string{movies[3].ID, "missing"}; fmt.Sprint(got.NotFound) != fmt.Sprint(want) {
+				t.Errorf("notFound = %v, want %v", got.NotFound, want)
+			}
+			if left := ids(ts.list("").Data); len(left) != 1 || left[0] != movies[1].ID {
+				t.Errorf("movies left = %v, want only %s", left, movies[1].ID)
+			}
+		})
+	}
+}
+
+func TestBatchDeleteCanonicalIDs(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	movie := ts.create(movieJSON("Heat"))
+
+	resp := ts.do("POST", "/v1/movies/batch-delete", fmt.Sprintf(`{"ids":[%q]}`, strings.ToUpper(movie.ID)))
+	expectStatus(t, resp, http.StatusOK)
+	if got := decodeBody[batchDeleteResult](t, resp); len(got.Deleted) != 1 || got.Deleted[0] != movie.ID {
+		t.Errorf("deleted = %v, want %s", got.Deleted, movie.ID)
+	}
+	expectStatus(t, ts.do("POST", "/v1/movies/batch-delete", `{"ids":["no/slashes"]}`), http.StatusUnprocessableEntity)
+}
+
+func TestHead(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	ts.create(`{"id":"1","isbn":"0306406152","title":"Heat","director":{"firstName":"Michael","lastName":"Mann"}}`)
+	for _, path := range []string{"/v1/movies/1", "/v1/movies"} {
+		get := ts.do("GET", path, "")
+		expectStatus(t, get, http.StatusOK)
+		readBody(t, get)
+
+		resp := ts.do("HEAD", path, "")
+		expectStatus(t, resp, http.StatusOK)
+		if body := readBody(t, resp); body != "" {
+			t.Errorf("HEAD %s sent a body: %q", path, body)
+		}
+		if got := resp.Header.Get("Content-Type"); got != "application/json" {
+			t.Errorf("HEAD %s Content-Type = %q, want application/json", path, got)
+		}
+		for _, name := range []string{"ETag", "Last-Modified"} {
+			if got, want := resp.Header.Get(name), get.Header.Get(name); got != want {
+				t.Errorf("HEAD %s %s = %q, want GET's %q", path, name, got, want)
+			}
+		}
+	}
+	expectStatus(t, ts.do("HEAD", "/v1/movies/2", ""), http.StatusNotFound)
+}
+
+func TestMovieExists(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	movie := ts.create(movieJSON("Heat"))
+	gone := ts.create(movieJSON("Ronin"))
+	ts.do("DELETE", "/v1/movies/"+gone.ID, "")
+
+	for _, tt := range []struct {
+		id     string
+		status int
+	}{
+		{movie.ID, http.StatusNoContent},
+		{gone.ID, http.StatusNotFound},
+		{"missing", http.StatusNotFound},
+	} {
+		resp := ts.do("GET", "/v1/movies/"+tt.id+"/exists", "")
+		expectStatus(t, resp, tt.status)
+		if body := readBody(t, resp); body != "" {
+			t.Errorf("exists for %s sent a body: %q", tt.id, body)
+		}
+	}
+}
+
+func TestPutCreatesOrUpdates(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+
+	resp := ts.do("PUT", "/v1/movies/heat-1995", movieJSON("Heat"))
+	expectStatus(t, resp, http.StatusCreated)
+	if got := resp.Header.Get("Location"); got != "/v1/movies/heat-1995" {
+		t.Errorf("Location = %q", got)
+	}
+	created := decodeBody[movieUpdate](t, resp).Movie
+	if created.ID != "heat-1995" || created.Version != 1 {
+		t.Errorf("created = %+v, want ID heat-1995 at version 1", created)
+	}
+
+	resp = ts.do("PUT", "/v1/movies/heat-1995", `{"isbn":"0306406152","title":"Heat (1995)","director":{"firstName":"Michael","lastName":"Mann"},"version":1}`)
+	expectStatus(t, resp, http.StatusOK)
+	if got := decodeBody[movieUpdate](t, resp).Movie; got.Title != "Heat (1995)" || got.Version != 2 {
+		t.Errorf("updated = %+v, want the new title at version 2", got)
+	}
+	if total := ts.list("").Total; total != 1 {
+		t.Errorf("total = %d, want 1", total)
+	}
+
+	// A deleted movie's ID is free again.
+	ts.do("DELETE", "/v1/movies/heat-1995", "")
+	expectStatus(t, ts.do("PUT", "/v1/movies/heat-1995", movieJSON("Heat")), http.StatusCreated)
+}
+
+func TestConcurrentPutCreatesOnce(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	const workers = 20
+	statuses := make(chan int, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest("PUT", ts.URL+"/v1/movies/race", strings.NewReader(movieJSON("Heat")))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := ts.Client().Do(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+			statuses <- resp.StatusCode
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+	created := 0
+	for status := range statuses {
+		switch status {
+		case http.StatusCreated:
+			created++
+		case http.StatusConflict, http.StatusUnprocessableEntity:
+		default:
+			t.Errorf("unexpected status %d", status)
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d PUTs created the movie, want 1", created)
+	}
+}
+
+func TestRandomMovie(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	expectStatus(t, ts.do("GET", "/v1/movies/random", ""), http.StatusNotFound)
+
+	movies := ts.createMovies(5)
+	gone := movies[4].ID
+	ts.do("DELETE", "/v1/movies/"+gone, "")
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		resp := ts.do("GET", "/v1/movies/random", "")
+		expectStatus(t, resp, http.StatusOK)
+		seen[decodeBody[Movie](t, resp).ID] = true
+	}
+	if seen[gone] {
+		t.Error("random returned a deleted movie")
+	}
+	// The chance of 100 draws from 4 movies all landing on one is 4^-99.
+	if len(seen) < 2 {
+		t.Errorf("100 random picks all returned %v", seen)
+	}
+}
+
+func TestStaleUpdateConflicts(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	for _, method := range []string{"PUT", "PATCH"} {
+		movie := ts.create(movieJSON("Heat"))
+		path := "/v1/movies/" + movie.ID
+		body := func(title string) string {
+			if method == "PATCH" {
+				return fmt.Sprintf(`{"title":%q,"version":%d}`, title, movie.Version)
+			}
+			return fmt.Sprintf(`{"isbn":"0306406152","title":%q,"director":{"firstName":"Michael","lastName":"Mann"},"version":%d}`, title, movie.Version)
+		}
+
+		// Both clients read version 1; the first to write wins.
+		expectStatus(t, ts.do(method, path, body("First")), http.StatusOK)
+		resp := ts.do(method, path, body("Second"))
+		expectStatus(t, resp, http.StatusConflict)
+		if got := decodeBody[errorBody](t, resp).Error.Code; got != "conflict" {
+			t.Errorf("%s conflict code = %q", method, got)
+		}
+		resp = ts.do("GET", path, "")
+		if got := decodeBody[Movie](t, resp); got.Title != "First" || got.Version != 2 {
+			t.Errorf("%s: stored %q at version %d, want First at 2", method, got.Title, got.Version)
+		}
+	}
+
+	movie := ts.create(movieJSON("Heat"))
+	for _, req := range []struct{ method, body string }{
+		{"PUT", movieJSON("Ronin")},
+		{"PATCH", `{"title":"Ronin"}`},
+	} {
+		resp := ts.do(req.method, "/v1/movies/"+movie.ID, req.body)
+		expectStatus(t, resp, http.StatusUnprocessableEntity)
+		if errs := decodeBody[[]FieldError](t, resp); len(errs) != 1 || errs[0].Field != "version" {
+			t.Errorf("%s without a version: errors %v, want one for version", req.method, errs)
+		}
+	}
+}
+
+func TestGetMovieByISBN(t *testing.T) {
+	logs := captureLogs(t)
+	ts := newTestServer(t, testConfig())
+	heat := ts.create(`{"isbn":"978-0-306-40615-7","title":"Heat","director":{"firstName":"Michael","lastName":"Mann"}}`)
+	ronin := ts.create(`{"isbn":"0306406152","title":"Ronin","director":{"firstName":"John","lastName":"Frankenheimer"}}`)
+
+	for _, isbn := range []string{"9780306406157", "978-0-306-40615-7"} {
+		resp := ts.do("GET", "/v1/movies/isbn/"+isbn, "")
+		expectStatus(t, resp, http.StatusOK)
+		if got := decodeBody[Movie](t, resp).ID; got != heat.ID {
+			t.Errorf("ISBN %s found %s, want %s", isbn, got, heat.ID)
+		}
+	}
+	expectStatus(t, ts.do("GET", "/v1/movies/isbn/9791090636071", ""), http.StatusNotFound)
+	expectStatus(t, ts.do("GET", "/v1/movies/isbn/9780306406158", ""), http.StatusBadRequest)
+
+	// A duplicate ISBN is a data bug: the first movie wins and it's logged.
+	ts.create(`{"isbn":"0306406152","title":"Ronin (Copy)","director":{"firstName":"John","lastName":"Frankenheimer"}}`)
+	resp := ts.do("GET", "/v1/movies/isbn/0306406152", "")
+	expectStatus(t, resp, http.StatusOK)
+	if got := decodeBody[Movie](t, resp).ID; got != ronin.ID {
+		t.Errorf("duplicate ISBN found %s, want the first, %s", got, ronin.ID)
+	}
+	if !strings.Contains(logs.String(), "several movies share an ISBN") {
+		t.Errorf("no warning logged for the duplicate ISBN: %s", logs)
+	}
+}
+
+func TestCreateMovieClientID(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	body := `{"id":"upstream-42","isbn":"0306406152","title":"Heat","director":{"firstName":"Michael","lastName":"Mann"}}`
+
+	resp := ts.do("POST", "/v1/movies", body)
+	expectStatus(t, resp, http.StatusCreated)
+	if got := decodeBody[Movie](t, resp).ID; got != "upstream-42" {
+		t.Errorf("ID = %q, want the client's upstream-42", got)
+	}
+	if got := resp.Header.Get("Location"); got != "/v1/movies/upstream-42" {
+		t.Errorf("Location = %q", got)
+	}
+
+	// The same movie again is still a conflict, dry run or not.
+	expectStatus(t, ts.do("POST", "/v1/movies", body), http.StatusConflict)
+	expectStatus(t, ts.do("POST", "/v1/movies?dry_run=true", body), http.StatusConflict)
+
+	expectStatus(t, ts.do("POST", "/v1/movies", `{"id":"not/valid","isbn":"0306406152","title":"Heat","director":{"firstName":"Michael","lastName":"Mann"}}`), http.StatusUnprocessableEntity)
+
+	if id := ts.create(movieJSON("Ronin")).ID; uuid.Validate(id) != nil {
+		t.Errorf("generated ID %q is not a UUID", id)
+	}
+}
+
+func TestCreateMovieReplayEmbedsCurrentDirector(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	resp := ts.do("POST", "/v1/directors", `{"firstName":"Michael","lastName":"Mann"}`)
+	expectStatus(t, resp, http.StatusCreated)
+	director := decodeBody[Director](t, resp)
+
+	body := fmt.Sprintf(`{"isbn":"0306406152","title":"Heat","directorId":%q}`, director.ID)
+	expectStatus(t, ts.do("POST", "/v1/movies", body, "Idempotency-Key", "k1"), http.StatusCreated)
+	expectStatus(t, ts.do("PUT", "/v1/directors/"+director.ID, `{"firstName":"Michael K.","lastName":"Mann"}`), http.StatusOK)
+
+	resp = ts.do("POST", "/v1/movies", body, "Idempotency-Key", "k1")
+	expectStatus(t, resp, http.StatusOK)
+	if got := decodeBody[Movie](t, resp).Director; got == nil || got.FirstName != "Michael K." {
+		t.Errorf("replayed director = %+v, want the renamed one", got)
+	}
+}
+
+func TestDuplicateMovie(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	source := ts.create(`{"isbn":"0306406152","title":"Heat","year":1995,"genres":["crime"],"director":{"firstName":"Michael","lastName":"Mann"}}`)
+	ts.do("PATCH", "/v1/movies/"+source.ID, `{"year":1996,"version":1}`)
+
+	resp := ts.do("POST", "/v1/movies/"+source.ID+"/duplicate", "")
+	expectStatus(t, resp, http.StatusCreated)
+	copied := decodeBody[Movie](t, resp)
+	if copied.ID == "" || copied.ID == source.ID {
+		t.Fatalf("copy ID = %q, want a new one", copied.ID)
+	}
+	if got := resp.Header.Get("Location"); got != "/v1/movies/"+copied.ID {
+		t.Errorf("Location = %q", got)
+	}
+	if copied.Title != "Heat (Copy)" || copied.ISBN != source.ISBN || copied.Year != 1996 ||
+		len(copied.Genres) != 1 || copied.Director == nil || copied.Director.LastName != "Mann" {
+		t.Errorf("copy = %+v, want the source's fields with a (Copy) title", copied)
+	}
+	// The copy is a new movie, not a continuation of the source's history.
+	if copied.Version != 1 || !copied.CreatedAt.Equal(copied.UpdatedAt) || !copied.CreatedAt.After(source.CreatedAt) {
+		t.Errorf("copy version %d, created %v, updated %v", copied.Version, copied.CreatedAt, copied.UpdatedAt)
+	}
+
+	resp = ts.do("GET", "/v1/movies/"+source.ID, "")
+	if got := decodeBody[Movie](t, resp); got.Title != "Heat" || got.Version != 2 {
+		t.Errorf("source after duplication = %+v", got)
+	}
+	if got := ts.list("").Total; got != 2 {
+		t.Errorf("total = %d, want 2", got)
+	}
+
+	resp = ts.do("POST", "/v1/movies/missing/duplicate", "")
+	expectStatus(t, resp, http.StatusNotFound)
+	if got := decodeBody[errorBody](t, resp).Error.Code; got != errorCode(http.StatusNotFound) {
+		t.Errorf("missing source: code = %q", got)
+	}
+	ts.do("DELETE", "/v1/movies/"+source.ID, "")
+	expectStatus(t, ts.do("POST", "/v1/movies/"+source.ID+"/duplicate", ""), http.StatusNotFound)
+}
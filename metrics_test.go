@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scrape GETs /metrics and returns the value of series, or 0 when it
+// hasn't been recorded yet.
+func (ts *testServer) scrape(series string) float64 {
+	ts.t.Helper()
+	resp := ts.do("GET", "/metrics", "")
+	expectStatus(ts.t, resp, http.StatusOK)
+	lines := bufio.NewScanner(resp.Body)
+	for lines.Scan() {
+		if value, ok := strings.CutPrefix(lines.Text(), series+" "); ok {
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				ts.t.Fatalf("%s: %v", lines.Text(), err)
+			}
+			return n
+		}
+	}
+	return 0
+}
+
+func TestMetrics(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	// The counters are global, so compare against what other tests left.
+	series := `http_requests_total{method="GET",path="/v1/movies/{id}",status="404"}`
+	before := ts.scrape(series)
+	ts.do("GET", "/v1/movies/a", "")
+	ts.do("GET", "/v1/movies/b", "")
+	if got := ts.scrape(series); got != before+2 {
+		t.Errorf("%s = %v, want %v", series, got, before+2)
+	}
+	if ts.scrape(`http_request_duration_seconds_count{method="GET",path="/v1/movies/{id}"}`) < 2 {
+		t.Error("latency histogram did not record the requests")
+	}
+	resp := ts.do("GET", "/metrics", "")
+	if body := readBody(t, resp); strings.Contains(body, `path="/v1/movies/a"`) {
+		t.Error("metrics are labelled with raw paths")
+	}
+}
+
+func TestInFlightRequests(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	const series = "http_requests_in_flight"
+	// The scrape counts itself.
+	idle := ts.scrape(series)
+
+	body, send := io.Pipe()
+	req, _ := http.NewRequest("POST", ts.URL+"/v1/movies", body)
+	req.Header.Set("Content-Type", "application/json")
+	done := make(chan *http.Response)
+	go func() {
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- resp
+	}()
+	// The count moves after the response is written, which the client
+	// may see first.
+	waitFor := func(want float64) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for ts.scrape(series) != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s = %v, want %v", series, ts.scrape(series), want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	io.WriteString(send, "{")
+	waitFor(idle + 1)
+	io.WriteString(send, movieJSON("Heat")[1:])
+	send.Close()
+	if resp := <-done; resp != nil {
+		expectStatus(t, resp, http.StatusCreated)
+		resp.Body.Close()
+	}
+	waitFor(idle)
+}
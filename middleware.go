@@ -0,0 +1,195 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Chain wraps h in mw so that the first middleware listed is the
+// outermost: it sees the request first and the response last.
+func Chain(h http.Handler, mw ...func(http.Handler) http.Handler) http.Handler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+	return h
+}
+
+// responseWriter records the status code and body size written through
+// it, which http.ResponseWriter doesn't expose.
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (rw *responseWriter) WriteHeader(status int) {
+	if rw.status == 0 {
+		rw.status = status
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	n, err := rw.ResponseWriter.Write(b)
+	rw.size += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// loggingMiddleware logs one structured line per request once it has been
+// served. A handler that panics is logged too, as the 500 that
+// recoverMiddleware, further out, answers it with.
+func loggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &responseWriter{ResponseWriter: w}
+		panicked := true
+		// Logging from a defer rather than recovering leaves the panic and
+		// its stack trace for recoverMiddleware.
+		defer func() {
+			if rw.status == 0 && panicked {
+				rw.status = http.StatusInternalServerError
+			}
+			if rw.status == 0 {
+				rw.status = http.StatusOK
+			}
+			slog.Info("request",
+				"request_id", requestIDFromContext(r.Context()),
+				"method", r.Method,
+				"path", r.URL.Path,
+				"status", rw.status,
+				"size", rw.size,
+				"duration", time.Since(start),
+			)
+		}()
+		next.ServeHTTP(rw, r)
+		panicked = false
+	})
+}
+
+// corsMiddleware adds CORS headers for requests from the allowed origins
+// and answers preflight requests itself, letting browsers cache the answer
+// for maxAge when it is set. An allowlist containing "*" allows every
+// origin. credentials lets browsers send cookies, but only to origins
+// listed by name, since they refuse credentials with a wildcard.
+func corsMiddleware(allowed []string, maxAge time.Duration, credentials bool) func(http.Handler) http.Handler {
+	allowAll := false
+	origins := make(map[string]bool, len(allowed))
+	for _, o := range allowed {
+		if o == "*" {
+			allowAll = true
+		}
+		origins[o] = true
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin != "" {
+				w.Header().Add("Vary", "Origin")
+				switch {
+				case allowAll:
+					w.Header().Set("Access-Control-Allow-Origin", "*")
+				case origins[origin]:
+					w.Header().Set("Access-Control-Allow-Origin", origin)
+					if credentials {
+						w.Header().Set("Access-Control-Allow-Credentials", "true")
+					}
+				}
+				if w.Header().Get("Access-Control-Allow-Origin") != "" {
+					w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS")
+					w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-API-Key, X-Request-ID, Idempotency-Key, If-Match, If-None-Match, If-Modified-Since")
+				}
+			}
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				if maxAge > 0 && w.Header().Get("Access-Control-Allow-Origin") != "" {
+					w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(maxAge.Seconds())))
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// defaultMaxBodyBytes caps request bodies unless MAX_BODY_BYTES says
+// otherwise.
+const defaultMaxBodyBytes = 1 << 20
+
+// maxBodyMiddleware stops reading request bodies after limit bytes so a
+// huge upload can't exhaust memory; decodeJSON turns that into a 413.
+func maxBodyMiddleware(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// trailingSlashMiddleware strips a trailing slash from the path before
+// routing, so /movies/ is served exactly like /movies. It rewrites rather
+// than redirects because clients don't reliably repeat a POST body after a
+// redirect.
+func trailingSlashMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.URL.Path) > 1 && strings.HasSuffix(r.URL.Path, "/") {
+			r.URL.Path = strings.TrimRight(r.URL.Path, "/")
+			if r.URL.Path == "" {
+				r.URL.Path = "/"
+			}
+			r.URL.RawPath = ""
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// deprecatedMiddleware marks responses from the unversioned routes as
+// deprecated and points clients at their /v1 successor, which sits under
+// basePath like the route itself.
+func deprecatedMiddleware(basePath string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			successor := basePath + "/v1" + strings.TrimPrefix(r.URL.Path, basePath)
+			w.Header().Set("Deprecation", "true")
+			w.Header().Set("Link", "<"+successor+">; rel=\"successor-version\"")
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// recoverMiddleware turns a panicking handler into a 500 response instead
+// of letting it take the server down, logging the stack trace.
+func recoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			slog.Error("panic serving request",
+				"request_id", requestIDFromContext(r.Context()),
+				"method", r.Method,
+				"path", r.URL.Path,
+				"error", err,
+				"stack", string(debug.Stack()),
+			)
+			writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
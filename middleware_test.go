@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureLogs sends the default logger's JSON output to a buffer until the
+// test ends.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	return &buf
+}
+
+func TestLoggingMiddleware(t *testing.T) {
+	logs := captureLogs(t)
+	h := loggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short and stout"))
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/movies?limit=1", nil))
+
+	var entry map[string]any
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatalf("log line %q: %v", logs, err)
+	}
+	want := map[string]any{
+		"msg":    "request",
+		"level":  "INFO",
+		"method": "GET",
+		"path":   "/v1/movies",
+		"status": float64(http.StatusTeapot),
+		"size":   float64(len("short and stout")),
+	}
+	for k, v := range want {
+		if entry[k] != v {
+			t.Errorf("%s = %v, want %v", k, entry[k], v)
+		}
+	}
+	if _, ok := entry["duration"].(float64); !ok {
+		t.Errorf("duration = %v, want a number", entry["duration"])
+	}
+}
+
+func TestLoggingMiddlewareDefaultStatus(t *testing.T) {
+	logs := captureLogs(t)
+	h := loggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/v1/movies/1", nil))
+	var entry struct{ Status int }
+	json.Unmarshal(logs.Bytes(), &entry)
+	if entry.Status != http.StatusOK {
+		t.Errorf("status = %d, want 200 when the handler writes nothing", entry.Status)
+	}
+}
+
+func TestLoggingMiddlewarePanic(t *testing.T) {
+	logs := captureLogs(t)
+	// The server's order: recovery outside logging.
+	h := recoverMiddleware(loggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/movies", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", rec.Code)
+	}
+
+	var logged bool
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var entry struct {
+			Msg    string
+			Status int
+			Stack  string
+		}
+		json.Unmarshal([]byte(line), &entry)
+		switch entry.Msg {
+		case "request":
+			logged = true
+			if entry.Status != http.StatusInternalServerError {
+				t.Errorf("access log status = %d, want 500", entry.Status)
+			}
+		case "panic serving request":
+			// The stack still leads to the handler that panicked.
+			if !strings.Contains(entry.Stack, "TestLoggingMiddlewarePanic") {
+				t.Errorf("panic stack lost the handler:\n%s", entry.Stack)
+			}
+		}
+	}
+	if !logged {
+		t.Errorf("no access log line for a panicking request: %s", logs)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	cfg := testConfig()
+	cfg.AllowedOrigins = []string{"https://app.example", "https://admin.example"}
+	ts := newTestServer(t, cfg)
+
+	resp := ts.do("OPTIONS", "/v1/movies", "", "Origin", "https://app.example", "Access-Control-Request-Method", "POST")
+	expectStatus(t, resp, http.StatusNoContent)
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "https://app.example" {
+		t.Errorf("preflight Allow-Origin = %q", got)
+	}
+	if got := resp.Header.Get("Access-Control-Allow-Methods"); !strings.Contains(got, "POST") {
+		t.Errorf("preflight Allow-Methods = %q, want POST among them", got)
+	}
+	if got := resp.Header.Get("Access-Control-Allow-Headers"); !strings.Contains(got, "Content-Type") || !strings.Contains(got, "X-API-Key") {
+		t.Errorf("preflight Allow-Headers = %q, want Content-Type and X-API-Key among them", got)
+	}
+	// No route reads Authorization; keys go in X-API-Key.
+	if got := resp.Header.Get("Access-Control-Allow-Headers"); strings.Contains(got, "Authorization") {
+		t.Errorf("preflight Allow-Headers = %q, want no Authorization", got)
+	}
+
+	resp = ts.do("GET", "/v1/movies", "", "Origin", "https://evil.example")
+	expectStatus(t, resp, http.StatusOK)
+	for _, h := range []string{"Access-Control-Allow-Origin", "Access-Control-Allow-Methods"} {
+		if got := resp.Header.Get(h); got != "" {
+			t.Errorf("disallowed origin got %s: %q", h, got)
+		}
+	}
+	if got := strings.Join(resp.Header.Values("Vary"), ", "); !strings.Contains(got, "Origin") {
+		t.Errorf("Vary = %q, want Origin", got)
+	}
+}
+
+func TestCORSDefaultAllowsAll(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	resp := ts.do("GET", "/v1/movies", "", "Origin", "https://anywhere.example")
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Allow-Origin = %q, want *", got)
+	}
+}
+
+func TestCORSMaxAgeAndCredentials(t *testing.T) {
+	cfg := testConfig()
+	cfg.AllowedOrigins = []string{"https://app.example"}
+	cfg.CORSMaxAge = 10 * time.Minute
+	cfg.CORSAllowCredentials = true
+	ts := newTestServer(t, cfg)
+
+	resp := ts.do("OPTIONS", "/v1/movies", "", "Origin", "https://app.example", "Access-Control-Request-Method", "POST")
+	expectStatus(t, resp, http.StatusNoContent)
+	if got := resp.Header.Get("Access-Control-Max-Age"); got != "600" {
+		t.Errorf("preflight Max-Age = %q, want 600", got)
+	}
+	if got := resp.Header.Get("Access-Control-Allow-Credentials"); got != "true" {
+		t.Errorf("preflight Allow-Credentials = %q, want true", got)
+	}
+
+	// A plain request isn't cached, but may carry cookies.
+	resp = ts.do("GET", "/v1/movies", "", "Origin", "https://app.example")
+	if got := resp.Header.Get("Access-Control-Max-Age"); got != "" {
+		t.Errorf("GET Max-Age = %q, want none", got)
+	}
+	if got := resp.Header.Get("Access-Control-Allow-Credentials"); got != "true" {
+		t.Errorf("GET Allow-Credentials = %q, want true", got)
+	}
+
+	resp = ts.do("OPTIONS", "/v1/movies", "", "Origin", "https://evil.example", "Access-Control-Request-Method", "POST")
+	for _, h := range []string{"Access-Control-Max-Age", "Access-Control-Allow-Credentials"} {
+		if got := resp.Header.Get(h); got != "" {
+			t.Errorf("disallowed origin got %s: %q", h, got)
+		}
+	}
+}
+
+func TestCORSWildcardWithoutCredentials(t *testing.T) {
+	// corsMiddleware never pairs * with credentials, even if asked to.
+	handler := corsMiddleware([]string{"*"}, 0, true)(http.NotFoundHandler())
+	req := httptest.NewRequest("OPTIONS", "/v1/movies", nil)
+	req.Header.Set("Origin", "https://anywhere.example")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Allow-Origin = %q, want *", got)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+		t.Errorf("Allow-Credentials = %q with a wildcard origin", got)
+	}
+	if got := rec.Header().Get("Access-Control-Max-Age"); got != "" {
+		t.Errorf("Max-Age = %q without one configured", got)
+	}
+}
+
+func TestRecoverMiddleware(t *testing.T) {
+	logs := captureLogs(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/panic", func(http.ResponseWriter, *http.Request) { panic("boom") })
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("fine")) })
+	srv := httptest.NewServer(recoverMiddleware(mux))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/panic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(t, resp, http.StatusInternalServerError)
+	if got := decodeBody[errorBody](t, resp).Error.Message; got != "Internal server error" {
+		t.Errorf("message = %q", got)
+	}
+	resp.Body.Close()
+	if !strings.Contains(logs.String(), `"error":"boom"`) || !strings.Contains(logs.String(), "goroutine") {
+		t.Errorf("panic log lacks the error or stack: %s", logs)
+	}
+
+	resp, err = http.Get(srv.URL + "/ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	expectStatus(t, resp, http.StatusOK)
+}
+
+func TestChainOrder(t *testing.T) {
+	var calls []string
+	trace := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name+" in")
+				next.ServeHTTP(w, r)
+				calls = append(calls, name+" out")
+			})
+		}
+	}
+	h := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, "handler")
+	}), trace("a"), trace("b"), trace("c"))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	want := "a in, b in, c in, handler, c out, b out, a out"
+	if got := strings.Join(calls, ", "); got != want {
+		t.Errorf("calls = %s, want %s", got, want)
+	}
+
+	calls = nil
+	Chain(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { calls = append(calls, "handler") })).
+		ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if len(calls) != 1 {
+		t.Errorf("empty chain calls = %v, want just the handler", calls)
+	}
+}
+
+func TestTrailingSlash(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	movie := ts.create(movieJSON("Heat"))
+	for _, path := range []string{"/v1/movies", "/movies", "/v1/movies/" + movie.ID, "/v1/movies/count"} {
+		want := readBody(t, ts.do("GET", path, ""))
+		for _, slashed := range []string{path + "/", path + "//"} {
+			resp := ts.do("GET", slashed, "")
+			expectStatus(t, resp, http.StatusOK)
+			if got := readBody(t, resp); got != want {
+				t.Errorf("GET %s = %s, want the same as %s: %s", slashed, got, path, want)
+			}
+		}
+	}
+	// Rewritten rather than redirected, so a POST keeps its body.
+	resp := ts.do("POST", "/v1/movies/", movieJSON("Ronin"))
+	expectStatus(t, resp, http.StatusCreated)
+	expectStatus(t, ts.do("GET", "/", ""), http.StatusNotFound)
+}
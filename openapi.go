@@ -0,0 +1,36 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+)
+
+// openAPISpec is the hand-maintained OpenAPI 3 description of the API.
+// Keep it in step with registerV1Routes; TestOpenAPISpec fails when they
+// drift apart.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// openAPIHandler serves openAPISpec with its server URL set to basePath,
+// so generated clients call the routes where they are mounted.
+func openAPIHandler(basePath string) http.Handler {
+	spec := openAPISpec
+	if basePath != "" {
+		var doc map[string]any
+		if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+			panic(err)
+		}
+		doc["servers"] = []map[string]string{{"url": basePath}}
+		b, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			panic(err)
+		}
+		spec = append(b, '\n')
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(spec)
+	})
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/gorilla/mux"
+)
+
+func TestOpenAPISpec(t *testing.T) {
+	doc, err := openapi3.NewLoader().LoadFromData(openAPISpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		t.Fatalf("invalid spec: %v", err)
+	}
+
+	// Every route registerV1Routes adds must be documented under /v1, and
+	// every documented /v1 operation must be a route.
+	r := mux.NewRouter()
+	registerV1Routes(r.PathPrefix("/v1").Subrouter(), &handler{})
+	routed := make(map[string]bool)
+	err = r.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		for _, method := range methods {
+			routed[method+" "+path] = true
+			item := doc.Paths.Find(path)
+			if item == nil || item.GetOperation(method) == nil {
+				t.Errorf("%s %s is routed but not in openapi.json", method, path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, item := range doc.Paths.Map() {
+		if len(path) < 4 || path[:4] != "/v1/" {
+			continue
+		}
+		for method := range item.Operations() {
+			if !routed[method+" "+path] {
+				t.Errorf("%s %s is in openapi.json but not routed", method, path)
+			}
+		}
+	}
+}
+
+func TestOpenAPIServedWithBasePath(t *testing.T) {
+	cfg := testConfig()
+	cfg.BasePath = "/api"
+	ts := newTestServer(t, cfg)
+	resp := ts.do("GET", "/api/openapi.json", "")
+	expectStatus(t, resp, http.StatusOK)
+	doc, err := openapi3.NewLoader().LoadFromData([]byte(readBody(t, resp)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Servers) != 1 || doc.Servers[0].URL != "/api" {
+		t.Errorf("servers = %v, want the base path /api", doc.Servers)
+	}
+}
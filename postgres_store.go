@@ -0,0 +1,419 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// postgresSchema creates the tables with their current columns. seq keeps
+// GetAll in insertion order, which SQLite gets from rowid.
+var postgresSchema = []string{`CREATE TABLE IF NOT EXISTS movies (
+	seq BIGSERIAL,
+	id TEXT PRIMARY KEY,
+	isbn TEXT NOT NULL,
+	title TEXT NOT NULL,
+	director_first_name TEXT,
+	director_last_name TEXT,
+	director_id TEXT,
+	deleted_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ,
+	updated_at TIMESTAMPTZ,
+	version INTEGER NOT NULL DEFAULT 1,
+	genres TEXT,
+	year INTEGER
+)`,
+	// Tables created before movies had versions, genres or years.
+	`ALTER TABLE movies ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1`,
+	`ALTER TABLE movies ADD COLUMN IF NOT EXISTS genres TEXT`,
+	`ALTER TABLE movies ADD COLUMN IF NOT EXISTS year INTEGER`,
+	`CREATE TABLE IF NOT EXISTS directors (
+	seq BIGSERIAL,
+	id TEXT PRIMARY KEY,
+	first_name TEXT NOT NULL,
+	last_name TEXT NOT NULL
+)`}
+
+// Connection pool settings for PostgresStore.
+const (
+	postgresMaxOpenConns    = 20
+	postgresMaxIdleConns    = 10
+	postgresConnMaxLifetime = 30 * time.Minute
+)
+
+// postgresConnectAttempts bounds how often NewPostgresStore tries to reach
+// the database, waiting a second longer after each failure, so the server
+// can start alongside a database that is still booting.
+const postgresConnectAttempts = 5
+
+// PostgresStore is a MovieStore kept in a PostgreSQL database.
+type PostgresStore struct {
+	db  *sql.DB
+	ids IDGenerator
+}
+
+// NewPostgresStore connects to the database at dsn, retrying while it is
+// unreachable, and creates the tables if they don't exist yet. New movies
+// and directors are named with ids, or with UUIDs when ids is nil.
+func NewPostgresStore(dsn string, ids IDGenerator) (*PostgresStore, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(postgresMaxOpenConns)
+	db.SetMaxIdleConns(postgresMaxIdleConns)
+	db.SetConnMaxLifetime(postgresConnMaxLifetime)
+	for attempt := 1; ; attempt++ {
+		if err = db.Ping(); err == nil {
+			break
+		}
+		if attempt == postgresConnectAttempts {
+			db.Close()
+			return nil, fmt.Errorf("postgres: giving up after %d attempts: %w", attempt, err)
+		}
+		log.Printf("postgres: connect attempt %d failed: %v", attempt, err)
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+	for _, stmt := range postgresSchema {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	ids = orUUID(ids)
+	if err := resumeIDs(db, ids); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &PostgresStore{db: db, ids: ids}, nil
+}
+
+// Close closes the connection pool.
+func (s *PostgresStore) Close() error {
+	return s.db.Close()
+}
+
+// Ping checks that the database is still reachable.
+func (s *PostgresStore) Ping() error {
+	return s.db.Ping()
+}
+
+// Directors returns a DirectorStore sharing this store's connection pool.
+func (s *PostgresStore) Directors() *PostgresDirectorStore {
+	return &PostgresDirectorStore{db: s.db, ids: s.ids}
+}
+
+func scanPostgresMovie(row rowScanner) (Movie, error) {
+	var movie Movie
+	var first, last, directorID, genres sql.NullString
+	var deletedAt, createdAt, updatedAt sql.NullTime
+	var year sql.NullInt64
+	if err := row.Scan(&movie.ID, &movie.ISBN, &movie.Title, &first, &last, &directorID, &deletedAt, &createdAt, &updatedAt, &movie.Version, &genres, &year); err != nil {
+		return Movie{}, err
+	}
+	movie.Year = int(year.Int64)
+	if first.Valid || last.Valid {
+		movie.Director = &Director{FirstName: first.String, LastName: last.String}
+	}
+	movie.DirectorID = directorID.String
+	var err error
+	if movie.Genres, err = parseGenres(genres); err != nil {
+		return Movie{}, err
+	}
+	if deletedAt.Valid {
+		t := deletedAt.Time.UTC()
+		movie.DeletedAt = &t
+	}
+	movie.CreatedAt = createdAt.Time.UTC()
+	movie.UpdatedAt = updatedAt.Time.UTC()
+	return movie, nil
+}
+
+// postgresTime passes t as a timestamp, or NULL when it's nil.
+func postgresTime(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
+
+func (s *PostgresStore) GetAll(ctx context.Context) ([]Movie, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+movieColumns+` FROM movies ORDER BY seq`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	movies := []Movie{}
+	for rows.Next() {
+		movie, err := scanPostgresMovie(rows)
+		if err != nil {
+			return nil, err
+		}
+		movies = append(movies, movie)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return movies, nil
+}
+
+func (s *PostgresStore) GetByID(ctx context.Context, id string) (Movie, bool, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+movieColumns+` FROM movies WHERE id = $1`, id)
+	movie, err := scanPostgresMovie(row)
+	if err == sql.ErrNoRows {
+		return Movie{}, false, nil
+	}
+	if err != nil {
+		return Movie{}, false, err
+	}
+	return movie, true, nil
+}
+
+func (s *PostgresStore) Create(ctx context.Context, movie Movie) (Movie, error) {
+	err := assignID(&movie.ID, s.ids, func(id string) bool {
+		// A failed lookup fails the insert too, with the same error.
+		_, ok, _ := s.GetByID(ctx, id)
+		return ok
+	})
+	if err != nil {
+		return Movie{}, err
+	}
+	if err := insertPostgresMovie(ctx, s.db, movie); err != nil {
+		return Movie{}, err
+	}
+	return movie, nil
+}
+
+func (s *PostgresStore) CreateMany(ctx context.Context, movies []Movie) ([]Movie, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	created, err := insertPostgresMovies(ctx, tx, s.ids, movies)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+func (s *PostgresStore) ReplaceAll(ctx context.Context, movies []Movie) ([]Movie, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM movies`); err != nil {
+		return nil, err
+	}
+	replaced, err := insertPostgresMovies(ctx, tx, s.ids, movies)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return replaced, nil
+}
+
+// insertPostgresMovies inserts movies within tx, assigning missing IDs
+// from ids.
+func insertPostgresMovies(ctx context.Context, tx *sql.Tx, ids IDGenerator, movies []Movie) ([]Movie, error) {
+	inserted := make([]Movie, len(movies))
+	for i, movie := range movies {
+		err := assignID(&movie.ID, ids, func(id string) bool {
+			var n int
+			tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM movies WHERE id = $1`, id).Scan(&n)
+			return n > 0
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := insertPostgresMovie(ctx, tx, movie); err != nil {
+			return nil, err
+		}
+		inserted[i] = movie
+	}
+	return inserted, nil
+}
+
+func insertPostgresMovie(ctx context.Context, db execer, movie Movie) error {
+	first, last := directorColumns(movie)
+	_, err := db.ExecContext(ctx, `INSERT INTO movies (`+movieColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		movie.ID, movie.ISBN, movie.Title, first, last, nullString(movie.DirectorID), postgresTime(movie.DeletedAt),
+		postgresTime(&movie.CreatedAt), postgresTime(&movie.UpdatedAt), movie.Version, genresColumn(movie.Genres), nullInt(movie.Year))
+	return err
+}
+
+func (s *PostgresStore) Update(ctx context.Context, id string, movie Movie) (Movie, bool, error) {
+	movie.ID = id
+	first, last := directorColumns(movie)
+	var createdAt sql.NullTime
+	err := s.db.QueryRowContext(ctx, `UPDATE movies SET isbn = $1, title = $2, director_first_name = $3, director_last_name = $4, director_id = $5, deleted_at = $6, updated_at = $7, genres = $8, year = $9, version = version + 1 WHERE id = $10 AND version = $11 RETURNING created_at, version`,
+		movie.ISBN, movie.Title, first, last, nullString(movie.DirectorID), postgresTime(movie.DeletedAt),
+		postgresTime(&movie.UpdatedAt), genresColumn(movie.Genres), nullInt(movie.Year), id, movie.Version).Scan(&createdAt, &movie.Version)
+	if err == sql.ErrNoRows {
+		return Movie{}, false, nil
+	}
+	if err != nil {
+		return Movie{}, false, err
+	}
+	movie.CreatedAt = createdAt.Time.UTC()
+	return movie, true, nil
+}
+
+func (s *PostgresStore) Put(ctx context.Context, id string, movie Movie) (Movie, bool, error) {
+	movie.ID = id
+	first, last := directorColumns(movie)
+	// Each statement is atomic on its own. Should another request create
+	// or update the movie between them, the insert changes nothing and
+	// the caller's version is stale, which is a conflict either way.
+	var createdAt sql.NullTime
+	err := s.db.QueryRowContext(ctx, `UPDATE movies SET isbn = $1, title = $2, director_first_name = $3, director_last_name = $4, director_id = $5, deleted_at = $6, updated_at = $7, genres = $8, year = $9, version = version + 1 WHERE id = $10 AND deleted_at IS NULL AND version = $11 RETURNING created_at, version`,
+		movie.ISBN, movie.Title, first, last, nullString(movie.DirectorID), postgresTime(movie.DeletedAt),
+		postgresTime(&movie.UpdatedAt), genresColumn(movie.Genres), nullInt(movie.Year), id, movie.Version).Scan(&createdAt, &movie.Version)
+	if err == nil {
+		movie.CreatedAt = createdAt.Time.UTC()
+		return movie, false, nil
+	}
+	if err != sql.ErrNoRows {
+		return Movie{}, false, err
+	}
+	movie.Version = 1
+	res, err := s.db.ExecContext(ctx, `INSERT INTO movies (`+movieColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		ON CONFLICT (id) DO UPDATE SET isbn = excluded.isbn, title = excluded.title,
+			director_first_name = excluded.director_first_name, director_last_name = excluded.director_last_name,
+			director_id = excluded.director_id, deleted_at = excluded.deleted_at,
+			created_at = excluded.created_at, updated_at = excluded.updated_at, version = excluded.version,
+			genres = excluded.genres, year = excluded.year
+		WHERE movies.deleted_at IS NOT NULL`,
+		movie.ID, movie.ISBN, movie.Title, first, last, nullString(movie.DirectorID), postgresTime(movie.DeletedAt),
+		postgresTime(&movie.CreatedAt), postgresTime(&movie.UpdatedAt), movie.Version, genresColumn(movie.Genres), nullInt(movie.Year))
+	if err != nil {
+		return Movie{}, false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return Movie{}, false, ErrVersionConflict
+	}
+	return movie, true, nil
+}
+
+func (s *PostgresStore) Delete(ctx context.Context, id string) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM movies WHERE id = $1`, id)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+func (s *PostgresStore) MarkDeleted(ctx context.Context, ids []string, at time.Time) (deleted, notFound []string, err error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+	deleted, notFound = []string{}, []string{}
+	for _, id := range ids {
+		res, err := tx.ExecContext(ctx, `UPDATE movies SET deleted_at = $1, version = version + 1 WHERE id = $2 AND deleted_at IS NULL`, postgresTime(&at), id)
+		if err != nil {
+			return nil, nil, err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			deleted = append(deleted, id)
+		} else {
+			notFound = append(notFound, id)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return deleted, notFound, nil
+}
+
+// PostgresDirectorStore is a DirectorStore kept in the directors table.
+type PostgresDirectorStore struct {
+	db  *sql.DB
+	ids IDGenerator
+}
+
+func (s *PostgresDirectorStore) GetAll(ctx context.Context) ([]Director, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, first_name, last_name FROM directors ORDER BY seq`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	directors := []Director{}
+	for rows.Next() {
+		var d Director
+		if err := rows.Scan(&d.ID, &d.FirstName, &d.LastName); err != nil {
+			return nil, err
+		}
+		directors = append(directors, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return directors, nil
+}
+
+func (s *PostgresDirectorStore) GetByID(ctx context.Context, id string) (Director, error) {
+	var d Director
+	err := s.db.QueryRowContext(ctx, `SELECT id, first_name, last_name FROM directors WHERE id = $1`, id).
+		Scan(&d.ID, &d.FirstName, &d.LastName)
+	if err == sql.ErrNoRows {
+		return Director{}, ErrNotFound
+	}
+	if err != nil {
+		return Director{}, err
+	}
+	return d, nil
+}
+
+func (s *PostgresDirectorStore) Create(ctx context.Context, director Director) (Director, error) {
+	err := assignID(&director.ID, s.ids, func(id string) bool {
+		// A failed lookup fails the insert too, with the same error.
+		_, err := s.GetByID(ctx, id)
+		return err == nil
+	})
+	if err != nil {
+		return Director{}, err
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT INTO directors (id, first_name, last_name) VALUES ($1, $2, $3)`,
+		director.ID, director.FirstName, director.LastName)
+	if err != nil {
+		return Director{}, err
+	}
+	return director, nil
+}
+
+func (s *PostgresDirectorStore) Update(ctx context.Context, id string, director Director) (Director, error) {
+	director.ID = id
+	res, err := s.db.ExecContext(ctx, `UPDATE directors SET first_name = $1, last_name = $2 WHERE id = $3`,
+		director.FirstName, director.LastName, id)
+	if err != nil {
+		return Director{}, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return Director{}, ErrNotFound
+	}
+	return director, nil
+}
+
+func (s *PostgresDirectorStore) Delete(ctx context.Context, id string) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM directors WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
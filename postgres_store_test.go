@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+// newTestPostgresStore connects to the database named by TEST_DATABASE_URL
+// and empties its tables, skipping the test when the variable is unset.
+// Point it at a database kept for tests.
+func newTestPostgresStore(t *testing.T) *PostgresStore {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	s, err := NewPostgresStore(dsn, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.Exec(`TRUNCATE movies, directors`); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// postgresMovie is testMovie with its times cut to the microseconds
+// PostgreSQL keeps.
+func postgresMovie(id, title string) Movie {
+	movie := testMovie(id, title)
+	movie.CreatedAt = movie.CreatedAt.Truncate(time.Microsecond)
+	movie.UpdatedAt = movie.CreatedAt
+	return movie
+}
+
+func TestPostgresStoreRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	s := newTestPostgresStore(t)
+
+	created, err := s.Create(ctx, postgresMovie("", "Heat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok, err := s.GetByID(ctx, created.ID); err != nil || !ok || !sameMovie(got, created) {
+		t.Fatalf("GetByID = %+v, %v, %v; want %+v", got, ok, err, created)
+	}
+
+	created.Title = "Heat (1995)"
+	if updated, ok, err := s.Update(ctx, created.ID, created); err != nil || !ok || updated.Title != "Heat (1995)" {
+		t.Fatalf("Update = %+v, %v, %v", updated, ok, err)
+	}
+	if _, err := s.Create(ctx, postgresMovie(created.ID, "Ronin")); err != ErrDuplicateID {
+		t.Errorf("Create with a taken ID: got %v, want ErrDuplicateID", err)
+	}
+
+	if ok, err := s.Delete(ctx, created.ID); err != nil || !ok {
+		t.Fatalf("Delete = %v, %v", ok, err)
+	}
+	if all, err := s.GetAll(ctx); err != nil || len(all) != 0 {
+		t.Errorf("GetAll after Delete = %+v, %v", all, err)
+	}
+}
+
+func TestPostgresStorePut(t *testing.T) {
+	ctx := context.Background()
+	s := newTestPostgresStore(t)
+
+	movie, created, err := s.Put(ctx, "heat", postgresMovie("", "Heat"))
+	if err != nil || !created {
+		t.Fatalf("Put to a free ID = %+v, %v, %v; want it created", movie, created, err)
+	}
+	movie.Title = "Heat (1995)"
+	if movie, created, err = s.Put(ctx, "heat", movie); err != nil || created || movie.Title != "Heat (1995)" {
+		t.Fatalf("Put to a taken ID = %+v, %v, %v; want it updated", movie, created, err)
+	}
+
+	deleted, notFound, err := s.MarkDeleted(ctx, []string{"heat", "missing"}, time.Now().UTC())
+	if err != nil || len(deleted) != 1 || len(notFound) != 1 {
+		t.Fatalf("MarkDeleted = %v, %v, %v", deleted, notFound, err)
+	}
+	// A soft-deleted movie's ID is free for PUT to create again.
+	if movie, created, err = s.Put(ctx, "heat", postgresMovie("", "Heat")); err != nil || !created {
+		t.Errorf("Put over a deleted movie = %+v, %v, %v; want it created", movie, created, err)
+	}
+}
+
+func TestPostgresStoreOverHTTP(t *testing.T) {
+	s := newTestPostgresStore(t)
+	ts := startServer(t, s, testConfig(), WithDirectorStore(s.Directors()))
+	director := ts.createDirector("Michael", "Mann")
+	movies := ts.createMovies(3)
+	ts.create(`{"isbn":"0306406152","title":"Heat","directorId":"` + director.ID + `"}`)
+	ts.do("DELETE", "/v1/movies/"+movies[1].ID, "")
+
+	list := ts.list("").Data
+	if len(list) != 3 || list[2].Director == nil || list[2].Director.ID != director.ID {
+		t.Errorf("list = %+v, want 3 movies, the last linked to %s", list, director.ID)
+	}
+}
+
+func TestPostgresStoreCancelled(t *testing.T) {
+	testCancelledStore(t, newTestPostgresStore(t))
+}
+
+func TestPostgresDirectorStoreNotFound(t *testing.T) {
+	testDirectorStoreNotFound(t, newTestPostgresStore(t).Directors())
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	const burst = 3
+	cfg := testConfig()
+	cfg.RateLimitRPS = 1
+	cfg.RateLimitBurst = burst
+	ts := newTestServer(t, cfg)
+	for i := 0; i < burst; i++ {
+		expectStatus(t, ts.do("GET", "/v1/movies", ""), http.StatusOK)
+	}
+	resp := ts.do("GET", "/v1/movies", "")
+	expectStatus(t, resp, http.StatusTooManyRequests)
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err != nil || secs < 1 {
+		t.Errorf("Retry-After = %q, want a whole number of seconds", resp.Header.Get("Retry-After"))
+	}
+	if got := decodeBody[errorBody](t, resp).Error.Code; got != "too_many_requests" {
+		t.Errorf("error code = %q", got)
+	}
+}
+
+func TestRateLimitPerIP(t *testing.T) {
+	rl := newRateLimiter(1, 1)
+	h := rateLimitMiddleware(rl)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	status := func(ip string) int {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if status("10.0.0.1") != http.StatusOK || status("10.0.0.1") != http.StatusTooManyRequests {
+		t.Error("second request from one IP was not limited")
+	}
+	if status("10.0.0.2") != http.StatusOK {
+		t.Error("another IP shared the first one's bucket")
+	}
+}
+
+func TestRateLimiterEvictIdle(t *testing.T) {
+	rl := newRateLimiter(1, 1)
+	rl.limiter("10.0.0.1")
+	rl.limiter("10.0.0.2")
+	rl.clients["10.0.0.1"].lastSeen = time.Now().Add(-time.Hour)
+	rl.evictIdle(time.Minute)
+	if _, ok := rl.clients["10.0.0.1"]; ok {
+		t.Error("idle client was kept")
+	}
+	if _, ok := rl.clients["10.0.0.2"]; !ok {
+		t.Error("active client was evicted")
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestRequestID(t *testing.T) {
+	logs := captureLogs(t)
+	ts := newTestServer(t, testConfig())
+
+	resp := ts.do("GET", "/v1/movies", "", "X-Request-ID", "trace-123")
+	if got := resp.Header.Get("X-Request-ID"); got != "trace-123" {
+		t.Errorf("echoed X-Request-ID = %q", got)
+	}
+	if !strings.Contains(logs.String(), `"request_id":"trace-123"`) {
+		t.Errorf("request ID missing from logs: %s", logs)
+	}
+
+	resp = ts.do("GET", "/v1/movies", "")
+	if got := resp.Header.Get("X-Request-ID"); uuid.Validate(got) != nil {
+		t.Errorf("generated X-Request-ID = %q, want a UUID", got)
+	}
+	// IDs that could forge log lines are replaced.
+	resp = ts.do("GET", "/v1/movies", "", "X-Request-ID", "a\tb")
+	if got := resp.Header.Get("X-Request-ID"); uuid.Validate(got) != nil {
+		t.Errorf("X-Request-ID for an invalid one = %q, want a fresh UUID", got)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	_ "embed"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// movieSchemaJSON is the JSON Schema movie request bodies must satisfy
+// before they are decoded into a Movie.
+//
+//go:embed movie.schema.json
+var movieSchemaJSON string
+
+var (
+	movieSchema     = jsonschema.MustCompileString("movie.schema.json", movieSchemaJSON)
+	movieListSchema = mustCompileMovieList()
+)
+
+// mustCompileMovieList compiles the schema for a bulk request: an array
+// whose items are each a movie.
+func mustCompileMovieList() *jsonschema.Schema {
+	c := jsonschema.NewCompiler()
+	if err := c.AddResource("movie.schema.json", strings.NewReader(movieSchemaJSON)); err != nil {
+		panic(err)
+	}
+	if err := c.AddResource("movies.schema.json", strings.NewReader(`{"type": "array", "items": {"$ref": "movie.schema.json"}}`)); err != nil {
+		panic(err)
+	}
+	return c.MustCompile("movies.schema.json")
+}
+
+// schemaViolations flattens a schema validation error into one FieldError
+// per failed leaf constraint.
+func schemaViolations(err error) []FieldError {
+	var ve *jsonschema.ValidationError
+	if !errors.As(err, &ve) {
+		return []FieldError{{Field: "body", Message: err.Error()}}
+	}
+	var errs []FieldError
+	var walk func(*jsonschema.ValidationError)
+	walk = func(e *jsonschema.ValidationError) {
+		if len(e.Causes) == 0 {
+			errs = append(errs, FieldError{Field: fieldPath(e.InstanceLocation), Message: e.Message})
+			return
+		}
+		for _, c := range e.Causes {
+			walk(c)
+		}
+	}
+	walk(ve)
+	return errs
+}
+
+// fieldPath turns a JSON pointer such as /0/director/firstName into the
+// dotted form FieldError uses, [0].director.firstName.
+func fieldPath(pointer string) string {
+	if pointer == "" {
+		return "body"
+	}
+	var b strings.Builder
+	for _, seg := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		seg = strings.NewReplacer("~1", "/", "~0", "~").Replace(seg)
+		if _, err := strconv.Atoi(seg); err == nil {
+			b.WriteString("[" + seg + "]")
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(seg)
+	}
+	return b.String()
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestMovieSchema(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	expectStatus(t, ts.do("POST", "/v1/movies", movieJSON("Heat")), http.StatusCreated)
+
+	resp := ts.do("POST", "/v1/movies", `{"isbn":"0306406152","title":42,"director":{"firstName":"Michael","lastName":true},"directorId":5}`)
+	expectStatus(t, resp, http.StatusUnprocessableEntity)
+	fields := make(map[string]bool)
+	for _, e := range decodeBody[[]FieldError](t, resp) {
+		fields[e.Field] = true
+	}
+	for _, want := range []string{"title", "director.lastName", "directorId"} {
+		if !fields[want] {
+			t.Errorf("violations %v are missing %s", fields, want)
+		}
+	}
+
+	// Bulk bodies name the offending item.
+	resp = ts.do("POST", "/v1/movies/bulk", fmt.Sprintf(`[%s,{"isbn":"0306406152","title":7,"director":{"firstName":"Michael","lastName":"Mann"}}]`, movieJSON("Ronin")))
+	expectStatus(t, resp, http.StatusUnprocessableEntity)
+	if errs := decodeBody[[]FieldError](t, resp); len(errs) != 1 || errs[0].Field != "[1].title" {
+		t.Errorf("bulk violations = %v, want one for [1].title", errs)
+	}
+}
+
+func TestFieldPath(t *testing.T) {
+	for pointer, want := range map[string]string{
+		"":                     "body",
+		"/title":               "title",
+		"/director/firstName":  "director.firstName",
+		"/0/director/lastName": "[0].director.lastName",
+		"/genres/2":            "genres[2]",
+		"/a~1b":                "a/b",
+	} {
+		if got := fieldPath(pointer); got != want {
+			t.Errorf("fieldPath(%q) = %q, want %q", pointer, got, want)
+		}
+	}
+}
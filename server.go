@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/time/rate"
+)
+
+// serverOptions holds everything NewServer can be told about, starting
+// from defaultConfig.
+type serverOptions struct {
+	cfg       Config
+	addr      string
+	directors DirectorStore
+	audit     AuditLog
+}
+
+// Option configures the server built by NewServer.
+type Option func(*serverOptions)
+
+// WithConfig applies cfg, usually the result of LoadConfig. Options after
+// it can still override individual settings.
+func WithConfig(cfg Config) Option {
+	return func(o *serverOptions) { o.cfg = cfg }
+}
+
+// WithAddr sets the address the server listens on, e.g. "127.0.0.1:0",
+// overriding the configured port.
+func WithAddr(addr string) Option {
+	return func(o *serverOptions) { o.addr = addr }
+}
+
+// WithDirectorStore sets the director store. Without it the server gets an
+// empty in-memory one.
+func WithDirectorStore(directors DirectorStore) Option {
+	return func(o *serverOptions) { o.directors = directors }
+}
+
+// WithAuditLog sets where changes to movies are recorded. Without it the
+// server keeps them in memory.
+func WithAuditLog(audit AuditLog) Option {
+	return func(o *serverOptions) { o.audit = audit }
+}
+
+// WithAPIKeys turns on API key checks for write requests. No keys leaves
+// writes open.
+func WithAPIKeys(keys []string) Option {
+	return func(o *serverOptions) { o.cfg.APIKeys = keys }
+}
+
+// WithRateLimit sets the per-client request rate and burst.
+func WithRateLimit(rps float64, burst int) Option {
+	return func(o *serverOptions) {
+		o.cfg.RateLimitRPS = rps
+		o.cfg.RateLimitBurst = burst
+	}
+}
+
+// NewServer wires store into a fully configured *http.Server without
+// binding a port, so callers decide when to ListenAndServe. The rate
+// limiter's cleanup goroutine and webhook retries stop when the server is
+// shut down.
+func NewServer(store MovieStore, opts ...Option) *http.Server {
+	o := serverOptions{cfg: defaultConfig()}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.addr == "" {
+		o.addr = ":" + o.cfg.Port
+	}
+	if o.directors == nil {
+		o.directors = newMemoryDirectorStore(nil)
+	}
+	if o.audit == nil {
+		o.audit = newMemoryAuditLog()
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	h := &handler{
+		store:          tracedStore{next: store},
+		directors:      o.directors,
+		idempotency:    newIdempotencyCache(o.cfg.IdempotencyTTL),
+		titlePolicy:    o.cfg.DuplicateTitlePolicy,
+		deleteResponse: o.cfg.DeleteResponse,
+		pageSize:       o.cfg.DefaultPageSize,
+		maxPageSize:    o.cfg.MaxPageSize,
+		basePath:       o.cfg.BasePath,
+		audit:          o.audit,
+		events:         newEventBroker(),
+		webhooks:       newWebhookDispatcher(ctx, o.cfg.WebhookURLs, o.cfg.WebhookTimeout, o.cfg.WebhookMaxAttempts),
+	}
+	h.touch()
+	limiter := newRateLimiter(rate.Limit(o.cfg.RateLimitRPS), o.cfg.RateLimitBurst)
+	go limiter.cleanup(ctx, time.Minute)
+
+	srv := &http.Server{
+		Addr:              o.addr,
+		Handler:           newRouter(h, limiter, o.cfg),
+		ReadHeaderTimeout: o.cfg.ReadHeaderTimeout,
+		ReadTimeout:       o.cfg.ReadTimeout,
+		WriteTimeout:      o.cfg.WriteTimeout,
+		IdleTimeout:       o.cfg.IdleTimeout,
+	}
+	srv.RegisterOnShutdown(stop)
+	// Shutdown waits for open connections, so event streams have to end.
+	srv.RegisterOnShutdown(h.events.close)
+	return srv
+}
+
+// newRouter registers every route on a fresh router, under cfg.BasePath
+// when one is set, and wraps it in the middleware stack. It panics if a
+// route is malformed, since that is a bug in this file rather than
+// something a caller can recover from.
+func newRouter(h *handler, limiter *rateLimiter, cfg Config) http.Handler {
+	root := mux.NewRouter()
+	root.Use(traceMiddleware)
+	root.Use(loggingMiddleware)
+	root.Use(metricsMiddleware)
+	root.Use(rateLimitMiddleware(limiter))
+	root.Use(readOnlyMiddleware(cfg.ReadOnly))
+	root.Use(authMiddleware(cfg.APIKeys))
+	root.Use(maxBodyMiddleware(cfg.MaxBodyBytes))
+
+	r := root
+	if cfg.BasePath != "" {
+		r = root.PathPrefix(cfg.BasePath).Subrouter()
+	}
+	r.HandleFunc("/healthz", h.healthz).Methods("GET")
+	r.Handle("/openapi.json", openAPIHandler(cfg.BasePath)).Methods("GET")
+	r.Handle("/metrics", promhttp.Handler()).Methods("GET")
+	registerV1Routes(r.PathPrefix("/v1").Subrouter(), h)
+
+	// The unprefixed routes stay for one release so existing clients keep
+	// working while they move to /v1.
+	legacy := r.NewRoute().Subrouter()
+	legacy.Use(deprecatedMiddleware(cfg.BasePath))
+	registerV1Routes(legacy, h)
+
+	// mux loses track of a method mismatch inside a subrouter and reports
+	// it as not found, so both cases go through unmatched to tell them
+	// apart again.
+	root.NotFoundHandler = unmatched(root)
+	root.MethodNotAllowedHandler = root.NotFoundHandler
+
+	if err := checkRoutes(root); err != nil {
+		panic(err)
+	}
+	return Chain(root,
+		inFlightMiddleware,
+		requestIDMiddleware,
+		gzipMiddleware,
+		recoverMiddleware,
+		corsMiddleware(cfg.AllowedOrigins, cfg.CORSMaxAge, cfg.CORSAllowCredentials),
+		trailingSlashMiddleware,
+	)
+}
+
+// registerV1Routes registers the version 1 movie and director routes on r.
+func registerV1Routes(r *mux.Router, h *handler) {
+	// HEAD shares the GET handlers; net/http drops the body and keeps the
+	// headers, including ETag and Content-Length.
+	r.HandleFunc("/movies", h.getMovies).Methods("GET", "HEAD")
+	r.HandleFunc("/movies/count", h.countMovies).Methods("GET")
+	// /directors is the director resource, so the directors extracted from
+	// movies live under /movies instead.
+	r.HandleFunc("/movies/directors", h.getMovieDirectors).Methods("GET")
+	r.HandleFunc("/movies/export", h.exportMovies).Methods("GET")
+	r.HandleFunc("/movies/events", h.streamEvents).Methods("GET")
+	r.HandleFunc("/movies/random", h.randomMovie).Methods("GET")
+	r.HandleFunc("/movies/recent", h.recentMovies).Methods("GET")
+	r.HandleFunc("/movies/isbn/{isbn}", h.getMovieByISBN).Methods("GET")
+	r.HandleFunc("/movies/{id}", h.getMovie).Methods("GET", "HEAD")
+	r.HandleFunc("/movies/{id}/exists", h.movieExists).Methods("GET")
+	r.HandleFunc("/movies", h.createMovie).Methods("POST")
+	r.HandleFunc("/movies/bulk", h.createMovies).Methods("POST")
+	r.HandleFunc("/movies/validate", h.validateMovieBody).Methods("POST")
+	r.HandleFunc("/movies/batch-delete", h.batchDeleteMovies).Methods("POST")
+	r.HandleFunc("/movies/import", h.importMovies).Methods("POST")
+	r.HandleFunc("/movies/{id}", h.updateMovie).Methods("PUT")
+	r.HandleFunc("/movies/{id}", h.patchMovie).Methods("PATCH")
+	r.HandleFunc("/movies/{id}/director", h.updateMovieDirector).Methods("PUT")
+	r.HandleFunc("/movies/{id}", h.deleteMovie).Methods("DELETE")
+	r.HandleFunc("/movies/{id}/restore", h.restoreMovie).Methods("POST")
+	r.HandleFunc("/movies/{id}/duplicate", h.duplicateMovie).Methods("POST")
+	r.HandleFunc("/audit", h.getAudit).Methods("GET")
+	r.HandleFunc("/stats", h.getStats).Methods("GET")
+	r.HandleFunc("/directors", h.getDirectors).Methods("GET")
+	r.HandleFunc("/directors/{id}", h.getDirector).Methods("GET")
+	r.HandleFunc("/directors", h.createDirector).Methods("POST")
+	r.HandleFunc("/directors/{id}", h.updateDirector).Methods("PUT")
+	r.HandleFunc("/directors/{id}", h.deleteDirector).Methods("DELETE")
+}
+
+// routeMethods are the methods unmatched offers in Allow.
+var routeMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+
+// unmatched handles requests no route of router matched. If the path is
+// served with another method it answers a JSON 405 whose Allow header
+// lists the methods that would have matched, and a JSON 404 otherwise.
+func unmatched(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var allowed []string
+		for _, method := range routeMethods {
+			probe := r.Clone(r.Context())
+			probe.Method = method
+			var match mux.RouteMatch
+			if router.Match(probe, &match) && match.MatchErr == nil {
+				allowed = append(allowed, method)
+			}
+		}
+		if allowed == nil {
+			writeJSONError(w, http.StatusNotFound, "route not found")
+			return
+		}
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	})
+}
+
+// checkRoutes walks the router and makes sure every registered path starts
+// with a slash, since mux silently never matches one that doesn't. mux
+// itself notices but only records it as the route's error, which nothing
+// else looks at.
+func checkRoutes(r *mux.Router) error {
+	return r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		if err := route.GetError(); err != nil {
+			return err
+		}
+		tpl, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		if !strings.HasPrefix(tpl, "/") {
+			return fmt.Errorf("route %q must begin with /", tpl)
+		}
+		return nil
+	})
+}
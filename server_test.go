@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVersionedAndLegacyRoutes(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	id := ts.create(movieJSON("Heat")).ID
+
+	resp := ts.do("GET", "/v1/movies/"+id, "")
+	expectStatus(t, resp, http.StatusOK)
+	if got := resp.Header.Get("Deprecation"); got != "" {
+		t.Errorf("/v1 route has Deprecation %q", got)
+	}
+
+	resp = ts.do("GET", "/movies/"+id, "")
+	expectStatus(t, resp, http.StatusOK)
+	if got := decodeBody[Movie](t, resp).ID; got != id {
+		t.Errorf("legacy route returned movie %q, want %q", got, id)
+	}
+	if got := resp.Header.Get("Deprecation"); got != "true" {
+		t.Errorf("Deprecation = %q, want true", got)
+	}
+	if got, want := resp.Header.Get("Link"), `</v1/movies/`+id+`>; rel="successor-version"`; got != want {
+		t.Errorf("Link = %q, want %q", got, want)
+	}
+
+	// Writes work on both too.
+	resp = ts.do("POST", "/movies", movieJSON("Ronin"))
+	expectStatus(t, resp, http.StatusCreated)
+	if total := ts.list("").Total; total != 2 {
+		t.Errorf("total = %d, want 2", total)
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	p := startMain(t, "READ_HEADER_TIMEOUT=100ms", "READ_TIMEOUT=200ms")
+
+	for name, stalled := range map[string]string{
+		"headers": "POST /v1/movies HTTP/1.1\r\nHost: x\r\n",
+		"body":    "POST /v1/movies HTTP/1.1\r\nHost: x\r\nContent-Type: application/json\r\nContent-Length: 100\r\n\r\n{\"title\":",
+	} {
+		conn, err := net.Dial("tcp", "127.0.0.1:"+p.port)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(conn, stalled)
+		// The server must hang up on its own, long before this deadline.
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		start := time.Now()
+		_, err = io.ReadAll(conn)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("stalled %s: connection still open after %v", name, time.Since(start))
+		}
+		conn.Close()
+	}
+}
+
+func TestNewServer(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore(nil, 0)
+	directors := newMemoryDirectorStore(nil)
+	srv := NewServer(store, WithConfig(testConfig()), WithDirectorStore(directors), WithAddr("127.0.0.1:9999"))
+	defer srv.Shutdown(context.Background())
+	if srv.Addr != "127.0.0.1:9999" {
+		t.Errorf("Addr = %q, want the WithAddr one", srv.Addr)
+	}
+	if srv.ReadHeaderTimeout != testConfig().ReadHeaderTimeout {
+		t.Errorf("ReadHeaderTimeout = %v, want the configured one", srv.ReadHeaderTimeout)
+	}
+
+	store.Create(ctx, testMovie("1", "Heat"))
+	store.Create(ctx, testMovie("2", "Ronin"))
+	director, _ := directors.Create(context.Background(), Director{FirstName: "Michael", LastName: "Mann"})
+	movie := movieJSON("Tenet")
+	// Every route, in an order where each request leaves what the next
+	// needs.
+	for _, tt := range []struct {
+		method, path, body string
+		status             int
+	}{
+		{"GET", "/healthz", "", http.StatusOK},
+		{"GET", "/openapi.json", "", http.StatusOK},
+		{"GET", "/metrics", "", http.StatusOK},
+		{"GET", "/v1/movies", "", http.StatusOK},
+		{"HEAD", "/v1/movies", "", http.StatusOK},
+		{"GET", "/v1/movies/count", "", http.StatusOK},
+		{"GET", "/v1/movies/directors", "", http.StatusOK},
+		{"GET", "/v1/movies/export", "", http.StatusOK},
+		{"GET", "/v1/movies/random", "", http.StatusOK},
+		{"GET", "/v1/movies/isbn/0306406152", "", http.StatusOK},
+		{"GET", "/v1/movies/1", "", http.StatusOK},
+		{"HEAD", "/v1/movies/1", "", http.StatusOK},
+		{"GET", "/v1/movies/1/exists", "", http.StatusNoContent},
+		{"POST", "/v1/movies", movie, http.StatusCreated},
+		{"POST", "/v1/movies/bulk", "[" + movie + "]", http.StatusCreated},
+		{"PUT", "/v1/movies/1", `{"isbn":"0306406152","title":"Heat","director":{"firstName":"Michael","lastName":"Mann"},"version":1}`, http.StatusOK},
+		{"PATCH", "/v1/movies/1", `{"title":"Heat 2","version":2}`, http.StatusOK},
+		{"DELETE", "/v1/movies/1", "", http.StatusOK},
+		{"POST", "/v1/movies/1/restore", "", http.StatusOK},
+		{"POST", "/v1/movies/batch-delete", `{"ids":["2"]}`, http.StatusOK},
+		{"GET", "/v1/directors", "", http.StatusOK},
+		{"GET", "/v1/directors/" + director.ID, "", http.StatusOK},
+		{"POST", "/v1/directors", `{"firstName":"John","lastName":"Carpenter"}`, http.StatusCreated},
+		{"PUT", "/v1/directors/" + director.ID, `{"firstName":"Michael K.","lastName":"Mann"}`, http.StatusOK},
+		{"DELETE", "/v1/directors/" + director.ID, "", http.StatusNoContent},
+		{"POST", "/v1/movies/import", "[" + movie + "]", http.StatusOK},
+	} {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		if tt.body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		rec := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s %s: status %d, want %d: %s", tt.method, tt.path, rec.Code, tt.status, rec.Body)
+		}
+	}
+}
+
+// writeSelfSignedCert writes a certificate for 127.0.0.1 and its key to
+// dir, returning their paths and a pool that trusts the certificate.
+func writeSelfSignedCert(t *testing.T, dir string) (certFile, keyFile string, pool *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	pool = x509.NewCertPool()
+	pool.AppendCertsFromPEM(certPEM)
+	return certFile, keyFile, pool
+}
+
+func TestTLS(t *testing.T) {
+	certFile, keyFile, pool := writeSelfSignedCert(t, t.TempDir())
+	p := startMain(t, "TLS_CERT_FILE="+certFile, "TLS_KEY_FILE="+keyFile)
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	resp, err := client.Get("https://127.0.0.1:" + p.port + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(t, resp, http.StatusOK)
+	if resp.TLS == nil {
+		t.Error("response did not come over TLS")
+	}
+	resp.Body.Close()
+
+	// Plain HTTP to the TLS port is refused.
+	if resp, err := http.Get("http://127.0.0.1:" + p.port + "/healthz"); err == nil {
+		if resp.StatusCode == http.StatusOK {
+			t.Error("plain HTTP request succeeded on the TLS port")
+		}
+		resp.Body.Close()
+	}
+}
+
+func TestLoadConfigTLS(t *testing.T) {
+	certFile, keyFile, _ := writeSelfSignedCert(t, t.TempDir())
+	t.Setenv("TLS_CERT_FILE", certFile)
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "TLS_KEY_FILE") {
+		t.Errorf("cert without key: got %v, want an error", err)
+	}
+	t.Setenv("TLS_KEY_FILE", keyFile+".missing")
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "TLS_KEY_FILE") {
+		t.Errorf("missing key file: got %v, want an error naming TLS_KEY_FILE", err)
+	}
+	t.Setenv("TLS_KEY_FILE", keyFile)
+	if cfg, err := LoadConfig(); err != nil || cfg.TLSCertFile != certFile || cfg.TLSKeyFile != keyFile {
+		t.Errorf("both files: got %+v, %v", cfg, err)
+	}
+}
+
+func TestBasePath(t *testing.T) {
+	for _, base := range []string{"", "/api", "/svc/movies"} {
+		t.Run("base="+base, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.BasePath = base
+			ts := newTestServer(t, cfg)
+
+			resp := ts.do("POST", base+"/v1/movies", movieJSON("Heat"))
+			expectStatus(t, resp, http.StatusCreated)
+			id := decodeBody[Movie](t, resp).ID
+			if got, want := resp.Header.Get("Location"), base+"/v1/movies/"+id; got != want {
+				t.Errorf("Location = %q, want %q", got, want)
+			}
+			expectStatus(t, ts.do("GET", base+"/v1/movies/"+id, ""), http.StatusOK)
+			expectStatus(t, ts.do("GET", base+"/healthz", ""), http.StatusOK)
+
+			resp = ts.do("GET", base+"/movies/"+id, "")
+			expectStatus(t, resp, http.StatusOK)
+			if got, want := resp.Header.Get("Link"), "<"+base+"/v1/movies/"+id+`>; rel="successor-version"`; got != want {
+				t.Errorf("legacy Link = %q, want %q", got, want)
+			}
+			if base != "" {
+				expectStatus(t, ts.do("GET", "/v1/movies/"+id, ""), http.StatusNotFound)
+			}
+		})
+	}
+}
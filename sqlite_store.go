@@ -0,0 +1,560 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const createMoviesTable = `CREATE TABLE IF NOT EXISTS movies (
+	id TEXT PRIMARY KEY,
+	isbn INTEGER NOT NULL,
+	title TEXT NOT NULL,
+	director_first_name TEXT,
+	director_last_name TEXT
+)`
+
+const createDirectorsTable = `CREATE TABLE IF NOT EXISTS directors (
+	id TEXT PRIMARY KEY,
+	first_name TEXT NOT NULL,
+	last_name TEXT NOT NULL
+)`
+
+// migrations evolve the tables past their original schema. They run in
+// order and each is recorded in PRAGMA user_version once applied.
+var migrations = []string{
+	`ALTER TABLE movies ADD COLUMN director_id TEXT`,
+	// ISBNs became strings so leading zeros and the X check digit survive.
+	`CREATE TABLE movies_new (
+		id TEXT PRIMARY KEY,
+		isbn TEXT NOT NULL,
+		title TEXT NOT NULL,
+		director_first_name TEXT,
+		director_last_name TEXT,
+		director_id TEXT
+	);
+	INSERT INTO movies_new SELECT id, CAST(isbn AS TEXT), title, director_first_name, director_last_name, director_id FROM movies;
+	DROP TABLE movies;
+	ALTER TABLE movies_new RENAME TO movies`,
+	`ALTER TABLE movies ADD COLUMN deleted_at TEXT`,
+	`ALTER TABLE movies ADD COLUMN created_at TEXT;
+	ALTER TABLE movies ADD COLUMN updated_at TEXT`,
+	`ALTER TABLE movies ADD COLUMN version INTEGER NOT NULL DEFAULT 1`,
+	`ALTER TABLE movies ADD COLUMN genres TEXT`,
+	`ALTER TABLE movies ADD COLUMN year INTEGER`,
+}
+
+// movieColumns lists the movies columns in the order scanMovie reads them.
+const movieColumns = `id, isbn, title, director_first_name, director_last_name, director_id, deleted_at, created_at, updated_at, version, genres, year`
+
+// SQLiteStore is a MovieStore persisted to a SQLite database file.
+type SQLiteStore struct {
+	db  *sql.DB
+	ids IDGenerator
+}
+
+// sqlitePragmas are set on every connection. The busy timeout makes a
+// write wait up to five seconds for another connection's lock, such as
+// another server's on the same file, instead of failing with SQLITE_BUSY,
+// and WAL mode lets reads go on while a write is in progress.
+const sqlitePragmas = "_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+
+// NewSQLiteStore opens the database at path and creates the movies table
+// if it doesn't exist yet. New movies and directors are named with ids, or
+// with UUIDs when ids is nil.
+func NewSQLiteStore(path string, ids IDGenerator) (*SQLiteStore, error) {
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	db, err := sql.Open("sqlite", path+sep+sqlitePragmas)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite takes one writer at a time, so a single connection queues
+	// this process's writes rather than having them contend for the lock.
+	db.SetMaxOpenConns(1)
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	ids = orUUID(ids)
+	if err := resumeIDs(db, ids); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteStore{db: db, ids: ids}, nil
+}
+
+// resumeIDs moves ids past every movie and director ID already in db, so
+// a sequence that restarted with the process doesn't hand them out again.
+// It reads nothing for generators that don't care.
+func resumeIDs(db *sql.DB, ids IDGenerator) error {
+	if _, ok := ids.(idSkipper); !ok {
+		return nil
+	}
+	rows, err := db.Query(`SELECT id FROM movies UNION ALL SELECT id FROM directors`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		skipPast(ids, id)
+	}
+	return rows.Err()
+}
+
+func migrate(db *sql.DB) error {
+	for _, stmt := range []string{createMoviesTable, createDirectorsTable} {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	for ; version < len(migrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[version]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", version+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the underlying database.
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// Ping checks that the database is still reachable.
+func (s *SQLiteStore) Ping() error {
+	return s.db.Ping()
+}
+
+// Directors returns a DirectorStore sharing this store's database.
+func (s *SQLiteStore) Directors() *SQLiteDirectorStore {
+	return &SQLiteDirectorStore{db: s.db, ids: s.ids}
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanMovie(row rowScanner) (Movie, error) {
+	var movie Movie
+	var first, last, directorID, deletedAt, createdAt, updatedAt, genres sql.NullString
+	var year sql.NullInt64
+	if err := row.Scan(&movie.ID, &movie.ISBN, &movie.Title, &first, &last, &directorID, &deletedAt, &createdAt, &updatedAt, &movie.Version, &genres, &year); err != nil {
+		return Movie{}, err
+	}
+	movie.Year = int(year.Int64)
+	if first.Valid || last.Valid {
+		movie.Director = &Director{FirstName: first.String, LastName: last.String}
+	}
+	movie.DirectorID = directorID.String
+	var err error
+	if movie.Genres, err = parseGenres(genres); err != nil {
+		return Movie{}, err
+	}
+	if movie.DeletedAt, err = parseNullTime(deletedAt); err != nil {
+		return Movie{}, err
+	}
+	for _, ts := range []struct {
+		src sql.NullString
+		dst *time.Time
+	}{{createdAt, &movie.CreatedAt}, {updatedAt, &movie.UpdatedAt}} {
+		t, err := parseNullTime(ts.src)
+		if err != nil {
+			return Movie{}, err
+		}
+		if t != nil {
+			*ts.dst = *t
+		}
+	}
+	return movie, nil
+}
+
+func directorColumns(movie Movie) (any, any) {
+	if movie.Director == nil {
+		return nil, nil
+	}
+	return movie.Director.FirstName, movie.Director.LastName
+}
+
+func nullString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// nullTime stores t as RFC 3339 text, or NULL when it's nil.
+func nullTime(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func nullInt(n int) any {
+	if n == 0 {
+		return nil
+	}
+	return n
+}
+
+// genresColumn stores genres as a JSON array, or NULL when there are none.
+func genresColumn(genres []string) any {
+	if len(genres) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(genres)
+	if err != nil {
+		panic(err) // A []string always marshals.
+	}
+	return string(b)
+}
+
+func parseGenres(s sql.NullString) ([]string, error) {
+	if !s.Valid {
+		return nil, nil
+	}
+	var genres []string
+	if err := json.Unmarshal([]byte(s.String), &genres); err != nil {
+		return nil, err
+	}
+	return genres, nil
+}
+
+func parseNullTime(s sql.NullString) (*time.Time, error) {
+	if !s.Valid {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s.String)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (s *SQLiteStore) GetAll(ctx context.Context) ([]Movie, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+movieColumns+` FROM movies ORDER BY rowid`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	movies := []Movie{}
+	for rows.Next() {
+		movie, err := scanMovie(rows)
+		if err != nil {
+			return nil, err
+		}
+		movies = append(movies, movie)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return movies, nil
+}
+
+func (s *SQLiteStore) GetByID(ctx context.Context, id string) (Movie, bool, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+movieColumns+` FROM movies WHERE id = ?`, id)
+	movie, err := scanMovie(row)
+	if err == sql.ErrNoRows {
+		return Movie{}, false, nil
+	}
+	if err != nil {
+		return Movie{}, false, err
+	}
+	return movie, true, nil
+}
+
+func (s *SQLiteStore) Create(ctx context.Context, movie Movie) (Movie, error) {
+	err := assignID(&movie.ID, s.ids, func(id string) bool {
+		// A failed lookup fails the insert too, with the same error.
+		_, ok, _ := s.GetByID(ctx, id)
+		return ok
+	})
+	if err != nil {
+		return Movie{}, err
+	}
+	if err := insertMovie(ctx, s.db, movie); err != nil {
+		return Movie{}, err
+	}
+	return movie, nil
+}
+
+func (s *SQLiteStore) CreateMany(ctx context.Context, movies []Movie) ([]Movie, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	created := make([]Movie, len(movies))
+	for i, movie := range movies {
+		err := assignID(&movie.ID, s.ids, func(id string) bool {
+			var n int
+			tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM movies WHERE id = ?`, id).Scan(&n)
+			return n > 0
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := insertMovie(ctx, tx, movie); err != nil {
+			return nil, err
+		}
+		created[i] = movie
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+func (s *SQLiteStore) ReplaceAll(ctx context.Context, movies []Movie) ([]Movie, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM movies`); err != nil {
+		return nil, err
+	}
+	replaced := make([]Movie, len(movies))
+	for i, movie := range movies {
+		err := assignID(&movie.ID, s.ids, func(id string) bool {
+			var n int
+			tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM movies WHERE id = ?`, id).Scan(&n)
+			return n > 0
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := insertMovie(ctx, tx, movie); err != nil {
+			return nil, err
+		}
+		replaced[i] = movie
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return replaced, nil
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func insertMovie(ctx context.Context, db execer, movie Movie) error {
+	first, last := directorColumns(movie)
+	_, err := db.ExecContext(ctx, `INSERT INTO movies (`+movieColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		movie.ID, movie.ISBN, movie.Title, first, last, nullString(movie.DirectorID), nullTime(movie.DeletedAt),
+		nullTime(&movie.CreatedAt), nullTime(&movie.UpdatedAt), movie.Version, genresColumn(movie.Genres), nullInt(movie.Year))
+	return err
+}
+
+func (s *SQLiteStore) Update(ctx context.Context, id string, movie Movie) (Movie, bool, error) {
+	movie.ID = id
+	first, last := directorColumns(movie)
+	var createdAt sql.NullString
+	err := s.db.QueryRowContext(ctx, `UPDATE movies SET isbn = ?, title = ?, director_first_name = ?, director_last_name = ?, director_id = ?, deleted_at = ?, updated_at = ?, genres = ?, year = ?, version = version + 1 WHERE id = ? AND version = ? RETURNING created_at, version`,
+		movie.ISBN, movie.Title, first, last, nullString(movie.DirectorID), nullTime(movie.DeletedAt),
+		nullTime(&movie.UpdatedAt), genresColumn(movie.Genres), nullInt(movie.Year), id, movie.Version).Scan(&createdAt, &movie.Version)
+	if err == sql.ErrNoRows {
+		return Movie{}, false, nil
+	}
+	if err != nil {
+		return Movie{}, false, err
+	}
+	movie.CreatedAt = time.Time{}
+	if t, err := parseNullTime(createdAt); err == nil && t != nil {
+		movie.CreatedAt = *t
+	}
+	return movie, true, nil
+}
+
+func (s *SQLiteStore) Put(ctx context.Context, id string, movie Movie) (Movie, bool, error) {
+	movie.ID = id
+	first, last := directorColumns(movie)
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Movie{}, false, err
+	}
+	defer tx.Rollback()
+	// Starting with a write takes the database's write lock, so no other
+	// connection can create or delete the movie between the statements.
+	var createdAt sql.NullString
+	err = tx.QueryRowContext(ctx, `UPDATE movies SET isbn = ?, title = ?, director_first_name = ?, director_last_name = ?, director_id = ?, deleted_at = ?, updated_at = ?, genres = ?, year = ?, version = version + 1 WHERE id = ? AND deleted_at IS NULL AND version = ? RETURNING created_at, version`,
+		movie.ISBN, movie.Title, first, last, nullString(movie.DirectorID), nullTime(movie.DeletedAt),
+		nullTime(&movie.UpdatedAt), genresColumn(movie.Genres), nullInt(movie.Year), id, movie.Version).Scan(&createdAt, &movie.Version)
+	created := err == sql.ErrNoRows
+	switch {
+	case created:
+		var live bool
+		err := tx.QueryRowContext(ctx, `SELECT deleted_at IS NULL FROM movies WHERE id = ?`, id).Scan(&live)
+		if err == nil && live {
+			return Movie{}, false, ErrVersionConflict
+		}
+		if err != nil && err != sql.ErrNoRows {
+			return Movie{}, false, err
+		}
+		movie.Version = 1
+		_, err = tx.ExecContext(ctx, `INSERT INTO movies (`+movieColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (id) DO UPDATE SET isbn = excluded.isbn, title = excluded.title,
+				director_first_name = excluded.director_first_name, director_last_name = excluded.director_last_name,
+				director_id = excluded.director_id, deleted_at = excluded.deleted_at,
+				created_at = excluded.created_at, updated_at = excluded.updated_at, version = excluded.version,
+				genres = excluded.genres, year = excluded.year`,
+			movie.ID, movie.ISBN, movie.Title, first, last, nullString(movie.DirectorID), nullTime(movie.DeletedAt),
+			nullTime(&movie.CreatedAt), nullTime(&movie.UpdatedAt), movie.Version, genresColumn(movie.Genres), nullInt(movie.Year))
+		if err != nil {
+			return Movie{}, false, err
+		}
+	case err != nil:
+		return Movie{}, false, err
+	default:
+		movie.CreatedAt = time.Time{}
+		if t, err := parseNullTime(createdAt); err == nil && t != nil {
+			movie.CreatedAt = *t
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return Movie{}, false, err
+	}
+	return movie, created, nil
+}
+
+func (s *SQLiteStore) Delete(ctx context.Context, id string) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM movies WHERE id = ?`, id)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+func (s *SQLiteStore) MarkDeleted(ctx context.Context, ids []string, at time.Time) (deleted, notFound []string, err error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+	deleted, notFound = []string{}, []string{}
+	for _, id := range ids {
+		res, err := tx.ExecContext(ctx, `UPDATE movies SET deleted_at = ?, version = version + 1 WHERE id = ? AND deleted_at IS NULL`, nullTime(&at), id)
+		if err != nil {
+			return nil, nil, err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			deleted = append(deleted, id)
+		} else {
+			notFound = append(notFound, id)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return deleted, notFound, nil
+}
+
+// SQLiteDirectorStore is a DirectorStore kept in the directors table.
+type SQLiteDirectorStore struct {
+	db  *sql.DB
+	ids IDGenerator
+}
+
+func (s *SQLiteDirectorStore) GetAll(ctx context.Context) ([]Director, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, first_name, last_name FROM directors ORDER BY rowid`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	directors := []Director{}
+	for rows.Next() {
+		var d Director
+		if err := rows.Scan(&d.ID, &d.FirstName, &d.LastName); err != nil {
+			return nil, err
+		}
+		directors = append(directors, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return directors, nil
+}
+
+func (s *SQLiteDirectorStore) GetByID(ctx context.Context, id string) (Director, error) {
+	var d Director
+	err := s.db.QueryRowContext(ctx, `SELECT id, first_name, last_name FROM directors WHERE id = ?`, id).
+		Scan(&d.ID, &d.FirstName, &d.LastName)
+	if err == sql.ErrNoRows {
+		return Director{}, ErrNotFound
+	}
+	if err != nil {
+		return Director{}, err
+	}
+	return d, nil
+}
+
+func (s *SQLiteDirectorStore) Create(ctx context.Context, director Director) (Director, error) {
+	err := assignID(&director.ID, s.ids, func(id string) bool {
+		// A failed lookup fails the insert too, with the same error.
+		_, err := s.GetByID(ctx, id)
+		return err == nil
+	})
+	if err != nil {
+		return Director{}, err
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT INTO directors (id, first_name, last_name) VALUES (?, ?, ?)`,
+		director.ID, director.FirstName, director.LastName)
+	if err != nil {
+		return Director{}, err
+	}
+	return director, nil
+}
+
+func (s *SQLiteDirectorStore) Update(ctx context.Context, id string, director Director) (Director, error) {
+	director.ID = id
+	res, err := s.db.ExecContext(ctx, `UPDATE directors SET first_name = ?, last_name = ? WHERE id = ?`,
+		director.FirstName, director.LastName, id)
+	if err != nil {
+		return Director{}, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return Director{}, ErrNotFound
+	}
+	return director, nil
+}
+
+func (s *SQLiteDirectorStore) Delete(ctx context.Context, id string) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM directors WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
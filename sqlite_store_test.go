@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestSQLiteStore opens a SQLite store in a file that lasts as long as
+// the test.
+func newTestSQLiteStore(t *testing.T) *SQLiteStore {
+	t.Helper()
+	s, err := NewSQLiteStore(filepath.Join(t.TempDir(), "movies.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// sameMovie reports whether a and b encode to the same JSON, which
+// compares times by instant rather than by representation.
+func sameMovie(a, b Movie) bool {
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return string(ja) == string(jb)
+}
+
+func TestSQLiteStoreRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "movies.db")
+	s, err := NewSQLiteStore(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created, err := s.Create(ctx, Movie{ISBN: "0306406152", Title: "Heat", Director: &Director{FirstName: "Michael", LastName: "Mann"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok, err := s.GetByID(ctx, created.ID); err != nil || !ok || !reflect.DeepEqual(got, created) {
+		t.Fatalf("GetByID = %+v, %v, %v; want %+v", got, ok, err, created)
+	}
+
+	created.Title = "Heat (1995)"
+	created.Director = nil
+	updated, ok, err := s.Update(ctx, created.ID, created)
+	if err != nil || !ok || updated.Version != created.Version+1 {
+		t.Fatalf("Update = %+v, %v, %v; want it at version %d", updated, ok, err, created.Version+1)
+	}
+	if _, ok, _ := s.Update(ctx, created.ID, created); ok {
+		t.Error("Update with a stale version succeeded")
+	}
+	if _, ok, _ := s.Update(ctx, "missing", created); ok {
+		t.Error("Update of a missing movie succeeded")
+	}
+
+	// The movie must outlive the connection.
+	s.Close()
+	if s, err = NewSQLiteStore(path, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, err := s.Create(ctx, Movie{ID: created.ID, Title: "Ronin"}); !errors.Is(err, ErrDuplicateID) {
+		t.Errorf("Create with a taken ID: got %v, want ErrDuplicateID", err)
+	}
+	all, err := s.GetAll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 || !reflect.DeepEqual(all[0], updated) {
+		t.Fatalf("after reopening, GetAll = %+v; want [%+v]", all, updated)
+	}
+
+	if ok, err := s.Delete(ctx, created.ID); err != nil || !ok {
+		t.Fatalf("Delete = %v, %v", ok, err)
+	}
+	if _, ok, err := s.GetByID(ctx, created.ID); err != nil || ok {
+		t.Errorf("GetByID after Delete = %v, %v", ok, err)
+	}
+	if ok, _ := s.Delete(ctx, created.ID); ok {
+		t.Error("second Delete reported a deletion")
+	}
+}
+
+func TestOpenStore(t *testing.T) {
+	cfg := testConfig()
+	store, _, err := openStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.(*memoryStore); !ok {
+		t.Errorf("without MOVIES_DB_PATH got %T, want the in-memory store", store)
+	}
+
+	cfg.DBPath = filepath.Join(t.TempDir(), "movies.db")
+	store, _, err = openStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, ok := store.(*SQLiteStore)
+	if !ok {
+		t.Fatalf("with MOVIES_DB_PATH got %T, want *SQLiteStore", store)
+	}
+	defer s.Close()
+
+	// The handlers work the same on top of it.
+	ts := startServer(t, s, testConfig())
+	id := ts.create(movieJSON("Heat")).ID
+	resp := ts.do("GET", "/v1/movies/"+id, "")
+	expectStatus(t, resp, http.StatusOK)
+	if got := decodeBody[Movie](t, resp).Title; got != "Heat" {
+		t.Errorf("title = %q, want Heat", got)
+	}
+}
+
+func TestSQLiteStoreCancelled(t *testing.T) {
+	testCancelledStore(t, newTestSQLiteStore(t))
+}
+
+func TestSQLiteStoreSoftDelete(t *testing.T) {
+	s := newTestSQLiteStore(t)
+	ctx := context.Background()
+	created, err := s.Create(ctx, testMovie("a", "Heat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	deleted, notFound, err := s.MarkDeleted(ctx, []string{"a", "b"}, at)
+	if err != nil || len(deleted) != 1 || len(notFound) != 1 {
+		t.Fatalf("MarkDeleted = %v, %v, %v", deleted, notFound, err)
+	}
+	got, _, _ := s.GetByID(ctx, created.ID)
+	if got.DeletedAt == nil || !got.DeletedAt.Equal(at) || got.Version != 2 {
+		t.Errorf("after MarkDeleted: deletedAt %v, version %d", got.DeletedAt, got.Version)
+	}
+}
+
+func TestSQLiteStoreConcurrentWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "movies.db")
+	// Two stores on one file stand for two server processes, which only
+	// the busy timeout keeps from failing each other's writes.
+	var stores [2]*SQLiteStore
+	for i := range stores {
+		s, err := NewSQLiteStore(path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		stores[i] = s
+	}
+	var mode string
+	if err := stores[0].db.QueryRow(`PRAGMA journal_mode`).Scan(&mode); err != nil || mode != "wal" {
+		t.Errorf("journal_mode = %q, %v; want wal", mode, err)
+	}
+
+	const writers, each = 8, 10
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*each)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(s *SQLiteStore) {
+			defer wg.Done()
+			for i := 0; i < each; i++ {
+				created, err := s.Create(ctx, testMovie("", "Heat"))
+				if err == nil {
+					created.Title = "Heat (1995)"
+					_, _, err = s.Update(ctx, created.ID, created)
+				}
+				if err != nil {
+					errs <- err
+				}
+			}
+		}(stores[w%len(stores)])
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("concurrent write: %v", err)
+	}
+	if all, err := stores[1].GetAll(ctx); err != nil || len(all) != writers*each {
+		t.Errorf("GetAll = %d movies, %v; want %d", len(all), err, writers*each)
+	}
+}
+
+func TestSQLiteDirectorStoreNotFound(t *testing.T) {
+	testDirectorStoreNotFound(t, newTestSQLiteStore(t).Directors())
+}
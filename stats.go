@@ -0,0 +1,74 @@
+package main
+
+import "net/http"
+
+// collectionStats is the summary GET /stats responds with. Only live
+// movies count, apart from Deleted.
+type collectionStats struct {
+	Movies    int `json:"movies"`
+	Deleted   int `json:"deleted"`
+	Directors int `json:"directors"`
+	// TopDirector is null when no live movie names a director.
+	TopDirector *directorCount `json:"topDirector"`
+}
+
+// directorCount is a director and how many movies they directed.
+type directorCount struct {
+	Director Director `json:"director"`
+	Movies   int      `json:"movies"`
+}
+
+func (h *handler) getStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	movies, err := h.store.GetAll(r.Context())
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	movies, err = h.withDirectors(r.Context(), movies)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	newJSONEncoder(w, r).Encode(movieStats(movies))
+}
+
+// movieStats summarizes movies in a single pass. Directors are told apart
+// by name as in distinctDirectors; of two equally prolific ones the top
+// director is the one that sorts first by last and then first name.
+func movieStats(movies []Movie) collectionStats {
+	type name struct{ first, last string }
+	counts := make(map[name]*directorCount)
+	var stats collectionStats
+	for _, movie := range movies {
+		if movie.DeletedAt != nil {
+			stats.Deleted++
+			continue
+		}
+		stats.Movies++
+		if movie.Director == nil {
+			continue
+		}
+		key := name{movie.Director.FirstName, movie.Director.LastName}
+		c, ok := counts[key]
+		if !ok {
+			c = &directorCount{Director: *movie.Director}
+			counts[key] = c
+		}
+		c.Movies++
+		if top := stats.TopDirector; top == nil || c.Movies > top.Movies ||
+			(c.Movies == top.Movies && sortsBefore(c.Director, top.Director)) {
+			stats.TopDirector = c
+		}
+	}
+	stats.Directors = len(counts)
+	return stats
+}
+
+// sortsBefore orders directors by last name and then first name.
+func sortsBefore(a, b Director) bool {
+	if a.LastName != b.LastName {
+		return a.LastName < b.LastName
+	}
+	return a.FirstName < b.FirstName
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMovieStats(t *testing.T) {
+	d := func(first, last string) *Director { return &Director{FirstName: first, LastName: last} }
+	deleted := time.Now()
+	movies := []Movie{
+		{Title: "Heat", Director: d("Michael", "Mann")},
+		{Title: "Halloween", Director: d("John", "Carpenter")},
+		{Title: "Thief", Director: d("Michael", "Mann")},
+		{Title: "The Thing", Director: d("John", "Carpenter")},
+		{Title: "Legacy"},
+		{Title: "Ronin", Director: d("John", "Frankenheimer")},
+		// Deleted movies don't count towards a director.
+		{Title: "Manhunter", Director: d("Michael", "Mann"), DeletedAt: &deleted},
+	}
+	got := movieStats(movies)
+	// Carpenter and Mann tie on two; Carpenter sorts first.
+	if got.Movies != 6 || got.Deleted != 1 || got.Directors != 3 ||
+		got.TopDirector == nil || got.TopDirector.Director.LastName != "Carpenter" || got.TopDirector.Movies != 2 {
+		t.Errorf("movieStats = %+v, top %+v", got, got.TopDirector)
+	}
+
+	if empty := movieStats(nil); empty != (collectionStats{}) {
+		t.Errorf("movieStats(nil) = %+v, want zeroes and no top director", empty)
+	}
+}
+
+func TestStatsRoute(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	ts.create(`{"isbn":"0306406152","title":"Heat","director":{"firstName":"Michael","lastName":"Mann"}}`)
+	ts.create(`{"isbn":"0306406152","title":"Thief","director":{"firstName":"Michael","lastName":"Mann"}}`)
+	gone := ts.create(`{"isbn":"0306406152","title":"Ronin","director":{"firstName":"John","lastName":"Frankenheimer"}}`)
+	ts.do("DELETE", "/v1/movies/"+gone.ID, "")
+
+	resp := ts.do("GET", "/v1/stats", "")
+	expectStatus(t, resp, http.StatusOK)
+	got := decodeBody[collectionStats](t, resp)
+	if got.Movies != 2 || got.Deleted != 1 || got.Directors != 1 || got.TopDirector == nil || got.TopDirector.Movies != 2 {
+		t.Errorf("stats = %+v, top %+v", got, got.TopDirector)
+	}
+}
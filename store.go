@@ -0,0 +1,446 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// MovieStore is the storage the handlers work against, so the in-memory
+// slice can be swapped for another backend. Every method takes the
+// request's context; once it is cancelled or past its deadline, methods
+// give up with ctx.Err() instead of finishing. A missing movie is not an
+// error: lookups report it with false and a nil error.
+type MovieStore interface {
+	// GetAll returns every movie, as an empty rather than nil slice when
+	// there are none so it always encodes as a JSON array.
+	GetAll(ctx context.Context) ([]Movie, error)
+	GetByID(ctx context.Context, id string) (Movie, bool, error)
+	Create(ctx context.Context, movie Movie) (Movie, error)
+	// CreateMany creates all of movies or, on error, none of them.
+	CreateMany(ctx context.Context, movies []Movie) ([]Movie, error)
+	// Update replaces the movie with the given ID in place, so it keeps
+	// its position in GetAll and its original CreatedAt. It only does so
+	// if the stored Version still equals movie.Version, and stores the
+	// next version; false means the movie is gone or has changed since.
+	Update(ctx context.Context, id string, movie Movie) (Movie, bool, error)
+	// Put stores movie under id in one atomic step: it updates the live
+	// movie with that ID like Update does, or creates the movie at that ID
+	// with version 1 when there is none, reporting created. A soft-deleted
+	// movie with the ID is replaced outright and counts as created. A live
+	// movie whose version isn't movie.Version gives ErrVersionConflict.
+	Put(ctx context.Context, id string, movie Movie) (stored Movie, created bool, err error)
+	Delete(ctx context.Context, id string) (bool, error)
+	// ReplaceAll swaps the whole collection for movies in one step,
+	// assigning IDs to those without one. On error the old collection is
+	// left as it was.
+	ReplaceAll(ctx context.Context, movies []Movie) ([]Movie, error)
+	// MarkDeleted soft-deletes every listed movie in one step, setting
+	// DeletedAt to at and moving each to its next version. IDs that don't
+	// exist or are already deleted come back in notFound.
+	MarkDeleted(ctx context.Context, ids []string, at time.Time) (deleted, notFound []string, err error)
+}
+
+// DirectorStore is the storage behind the /directors routes. Like
+// MovieStore its methods take the request context and report failures as
+// errors; a director that doesn't exist is ErrNotFound.
+type DirectorStore interface {
+	GetAll(ctx context.Context) ([]Director, error)
+	GetByID(ctx context.Context, id string) (Director, error)
+	Create(ctx context.Context, director Director) (Director, error)
+	Update(ctx context.Context, id string, director Director) (Director, error)
+	Delete(ctx context.Context, id string) error
+}
+
+var (
+	// ErrDuplicateID is returned by Create when the record carries an ID
+	// that is already taken.
+	ErrDuplicateID = errors.New("ID already exists")
+	// ErrVersionConflict is returned by Put when the stored movie's
+	// version isn't the one the caller last read.
+	ErrVersionConflict = errors.New("movie has been modified")
+	// ErrIDExhausted is returned by Create when no free ID turned up
+	// within maxIDAttempts tries.
+	ErrIDExhausted = errors.New("could not generate a unique ID")
+	// ErrStoreFull is returned by the in-memory store when a write would
+	// take it past its configured maximum number of movies.
+	ErrStoreFull = errors.New("movie store is full")
+	// ErrNotFound is returned by a DirectorStore when no director has the
+	// ID asked for.
+	ErrNotFound = errors.New("director not found")
+)
+
+// maxIDAttempts bounds how many random IDs Create tries before giving up.
+const maxIDAttempts = 10
+
+// assignID keeps a client-supplied ID if it's free, or takes the first one
+// from ids that exists reports as unused.
+func assignID(id *string, ids IDGenerator, exists func(id string) bool) error {
+	if *id != "" {
+		if exists(*id) {
+			return ErrDuplicateID
+		}
+		skipPast(ids, *id)
+		return nil
+	}
+	for i := 0; i < maxIDAttempts; i++ {
+		candidate := ids.Next()
+		if !exists(candidate) {
+			*id = candidate
+			return nil
+		}
+	}
+	return ErrIDExhausted
+}
+
+// memoryStore keeps movies in a slice, guarded by a lock since handlers
+// run concurrently. index maps each ID to its position in the slice so
+// lookups don't have to scan it. max caps how many movies the slice may
+// hold, with 0 meaning no cap. Soft-deleted movies count towards it until
+// makeRoom needs their space.
+type memoryStore struct {
+	mu     sync.RWMutex
+	movies []Movie
+	index  map[string]int
+	ids    IDGenerator
+	max    int
+}
+
+// newMemoryStore returns an empty store that names new movies with ids,
+// or with UUIDs when ids is nil, and holds at most max movies, or any
+// number when max is 0.
+func newMemoryStore(ids IDGenerator, max int) *memoryStore {
+	return &memoryStore{index: make(map[string]int), ids: orUUID(ids), max: max}
+}
+
+func (s *memoryStore) GetAll(ctx context.Context) ([]Movie, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]Movie, len(s.movies))
+	copy(out, s.movies)
+	return out, nil
+}
+
+func (s *memoryStore) GetByID(ctx context.Context, id string) (Movie, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return Movie{}, false, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	movie, ok := s.find(id)
+	return movie, ok, nil
+}
+
+// find looks up a movie by ID; callers must hold the lock.
+func (s *memoryStore) find(id string) (Movie, bool) {
+	if i, ok := s.index[id]; ok {
+		return s.movies[i], true
+	}
+	return Movie{}, false
+}
+
+// makeRoom reports whether n more movies fit within max. When they only
+// fit without some soft-deleted movies, it purges as many of those as
+// needed for good, oldest first, so deleting movies frees up room. If even
+// that isn't enough it changes nothing. Callers must hold the lock.
+func (s *memoryStore) makeRoom(n int) bool {
+	if s.max == 0 {
+		return true
+	}
+	excess := len(s.movies) + n - s.max
+	if excess <= 0 {
+		return true
+	}
+	deleted := 0
+	for _, m := range s.movies {
+		if m.DeletedAt != nil {
+			deleted++
+		}
+	}
+	if deleted < excess {
+		return false
+	}
+	kept := s.movies[:0]
+	for _, m := range s.movies {
+		if m.DeletedAt != nil && excess > 0 {
+			delete(s.index, m.ID)
+			excess--
+			continue
+		}
+		kept = append(kept, m)
+	}
+	s.movies = kept
+	for i, m := range s.movies {
+		s.index[m.ID] = i
+	}
+	return true
+}
+
+// add appends movie and indexes it; callers must hold the lock.
+func (s *memoryStore) add(movie Movie) {
+	s.index[movie.ID] = len(s.movies)
+	s.movies = append(s.movies, movie)
+}
+
+func (s *memoryStore) Create(ctx context.Context, movie Movie) (Movie, error) {
+	if err := ctx.Err(); err != nil {
+		return Movie{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.makeRoom(1) {
+		return Movie{}, ErrStoreFull
+	}
+	err := assignID(&movie.ID, s.ids, func(id string) bool {
+		_, ok := s.find(id)
+		return ok
+	})
+	if err != nil {
+		return Movie{}, err
+	}
+	s.add(movie)
+	return movie, nil
+}
+
+func (s *memoryStore) CreateMany(ctx context.Context, movies []Movie) ([]Movie, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.makeRoom(len(movies)) {
+		return nil, ErrStoreFull
+	}
+	created := make([]Movie, len(movies))
+	taken := make(map[string]bool, len(movies))
+	for i, movie := range movies {
+		err := assignID(&movie.ID, s.ids, func(id string) bool {
+			_, ok := s.find(id)
+			return ok || taken[id]
+		})
+		if err != nil {
+			return nil, err
+		}
+		taken[movie.ID] = true
+		created[i] = movie
+	}
+	for _, movie := range created {
+		s.add(movie)
+	}
+	return created, nil
+}
+
+func (s *memoryStore) ReplaceAll(ctx context.Context, movies []Movie) ([]Movie, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.max > 0 && len(movies) > s.max {
+		return nil, ErrStoreFull
+	}
+	replaced := make([]Movie, len(movies))
+	taken := make(map[string]bool, len(movies))
+	for i, movie := range movies {
+		err := assignID(&movie.ID, s.ids, func(id string) bool { return taken[id] })
+		if err != nil {
+			return nil, err
+		}
+		taken[movie.ID] = true
+		replaced[i] = movie
+	}
+	s.movies = replaced
+	s.index = make(map[string]int, len(replaced))
+	for i, movie := range replaced {
+		s.index[movie.ID] = i
+	}
+	return replaced, nil
+}
+
+func (s *memoryStore) Update(ctx context.Context, id string, movie Movie) (Movie, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return Movie{}, false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, ok := s.index[id]
+	if !ok || s.movies[i].Version != movie.Version {
+		return Movie{}, false, nil
+	}
+	movie.ID = id
+	movie.CreatedAt = s.movies[i].CreatedAt
+	movie.Version++
+	s.movies[i] = movie
+	return movie, true, nil
+}
+
+func (s *memoryStore) Put(ctx context.Context, id string, movie Movie) (Movie, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return Movie{}, false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	movie.ID = id
+	i, ok := s.index[id]
+	if !ok {
+		if !s.makeRoom(1) {
+			return Movie{}, false, ErrStoreFull
+		}
+		movie.Version = 1
+		s.add(movie)
+		return movie, true, nil
+	}
+	item := s.movies[i]
+	created := item.DeletedAt != nil
+	if created {
+		movie.Version = 1
+	} else {
+		if item.Version != movie.Version {
+			return Movie{}, false, ErrVersionConflict
+		}
+		movie.CreatedAt = item.CreatedAt
+		movie.Version++
+	}
+	s.movies[i] = movie
+	return movie, created, nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, id string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, ok := s.index[id]
+	if !ok {
+		return false, nil
+	}
+	s.movies = append(s.movies[:i], s.movies[i+1:]...)
+	delete(s.index, id)
+	// Everything after the removed movie moved down one place.
+	for j := i; j < len(s.movies); j++ {
+		s.index[s.movies[j].ID] = j
+	}
+	return true, nil
+}
+
+func (s *memoryStore) MarkDeleted(ctx context.Context, ids []string, at time.Time) (deleted, notFound []string, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deleted, notFound = []string{}, []string{}
+	for _, id := range ids {
+		i, ok := s.index[id]
+		if ok && s.movies[i].DeletedAt == nil {
+			t := at
+			s.movies[i].DeletedAt = &t
+			s.movies[i].Version++
+			deleted = append(deleted, id)
+		} else {
+			notFound = append(notFound, id)
+		}
+	}
+	return deleted, notFound, nil
+}
+
+// memoryDirectorStore keeps directors in a slice under a lock, like
+// memoryStore does for movies.
+type memoryDirectorStore struct {
+	mu        sync.RWMutex
+	directors []Director
+	ids       IDGenerator
+}
+
+// newMemoryDirectorStore returns an empty store that names new directors
+// with ids, or with UUIDs when ids is nil.
+func newMemoryDirectorStore(ids IDGenerator) *memoryDirectorStore {
+	return &memoryDirectorStore{ids: orUUID(ids)}
+}
+
+func (s *memoryDirectorStore) GetAll(ctx context.Context) ([]Director, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]Director, len(s.directors))
+	copy(out, s.directors)
+	return out, nil
+}
+
+func (s *memoryDirectorStore) GetByID(ctx context.Context, id string) (Director, error) {
+	if err := ctx.Err(); err != nil {
+		return Director{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	director, ok := s.find(id)
+	if !ok {
+		return Director{}, ErrNotFound
+	}
+	return director, nil
+}
+
+// find looks up a director by ID; callers must hold the lock.
+func (s *memoryDirectorStore) find(id string) (Director, bool) {
+	for _, item := range s.directors {
+		if item.ID == id {
+			return item, true
+		}
+	}
+	return Director{}, false
+}
+
+func (s *memoryDirectorStore) Create(ctx context.Context, director Director) (Director, error) {
+	if err := ctx.Err(); err != nil {
+		return Director{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := assignID(&director.ID, s.ids, func(id string) bool {
+		_, ok := s.find(id)
+		return ok
+	})
+	if err != nil {
+		return Director{}, err
+	}
+	s.directors = append(s.directors, director)
+	return director, nil
+}
+
+func (s *memoryDirectorStore) Update(ctx context.Context, id string, director Director) (Director, error) {
+	if err := ctx.Err(); err != nil {
+		return Director{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, item := range s.directors {
+		if item.ID == id {
+			director.ID = id
+			s.directors[i] = director
+			return director, nil
+		}
+	}
+	return Director{}, ErrNotFound
+}
+
+func (s *memoryDirectorStore) Delete(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, item := range s.directors {
+		if item.ID == id {
+			s.directors = append(s.directors[:i], s.directors[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
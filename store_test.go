@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// testMovie is a valid movie with the given ID and title.
+func testMovie(id, title string) Movie {
+	return Movie{
+		ID:       id,
+		ISBN:     "0306406152",
+		Title:    title,
+		Director: &Director{FirstName: "Christopher", LastName: "Nolan"},
+		Version:  1,
+	}
+}
+
+func TestAssignID(t *testing.T) {
+	taken := func(id string) bool { return id == "taken" }
+
+	id := "fresh"
+	if err := assignID(&id, uuidGenerator{}, taken); err != nil || id != "fresh" {
+		t.Errorf("free client ID: got %q, %v", id, err)
+	}
+	id = "taken"
+	if err := assignID(&id, uuidGenerator{}, taken); !errors.Is(err, ErrDuplicateID) {
+		t.Errorf("taken client ID: got %v, want ErrDuplicateID", err)
+	}
+	id = ""
+	if err := assignID(&id, uuidGenerator{}, taken); err != nil || id == "" {
+		t.Errorf("generated ID: got %q, %v", id, err)
+	}
+
+	asked := 0
+	everything := func(string) bool { asked++; return true }
+	id = ""
+	if err := assignID(&id, uuidGenerator{}, everything); !errors.Is(err, ErrIDExhausted) {
+		t.Errorf("no free IDs: got %v, want ErrIDExhausted", err)
+	}
+	if asked != maxIDAttempts {
+		t.Errorf("tried %d IDs, want %d", asked, maxIDAttempts)
+	}
+}
+
+func TestCreateMovieDuplicateID(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	body := `{"id":"heat","isbn":"0306406152","title":"Heat","director":{"firstName":"Michael","lastName":"Mann"}}`
+	if got := ts.create(body).ID; got != "heat" {
+		t.Fatalf("ID = %q, want the one sent", got)
+	}
+
+	resp := ts.do("POST", "/v1/movies", body)
+	expectStatus(t, resp, http.StatusConflict)
+	if got := decodeBody[errorBody](t, resp).Error.Message; got != ErrDuplicateID.Error() {
+		t.Errorf("error = %q", got)
+	}
+	if all := ts.list("").Data; len(all) != 1 {
+		t.Errorf("store holds %d movies, want 1", len(all))
+	}
+}
+
+func TestUUIDIDs(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	first := ts.create(movieJSON("Heat")).ID
+	second := ts.create(movieJSON("Heat")).ID
+	if first == second {
+		t.Fatalf("two movies got the same ID %q", first)
+	}
+	for _, id := range []string{first, second} {
+		u, err := uuid.Parse(id)
+		if err != nil || u.Version() != 4 || u.String() != id {
+			t.Errorf("ID %q is not a canonical UUIDv4 (%v)", id, err)
+		}
+	}
+}
+
+func TestSeparateStoresStartDifferently(t *testing.T) {
+	// Each store stands for a server process; a restart must not replay
+	// the IDs the previous one handed out.
+	ctx := context.Background()
+	first := make(map[string]bool)
+	for i := 0; i < 5; i++ {
+		movie, err := newMemoryStore(nil, 0).Create(ctx, testMovie("", "Heat"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first[movie.ID] {
+			t.Fatalf("two stores both started with ID %s", movie.ID)
+		}
+		first[movie.ID] = true
+	}
+}
+
+func TestMemoryStoreIndexAfterDelete(t *testing.T) {
+	ctx := context.Background()
+	s := newMemoryStore(nil, 0)
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		s.Create(ctx, testMovie(id, id))
+	}
+	for _, id := range []string{"b", "e", "a"} {
+		if ok, _ := s.Delete(ctx, id); !ok {
+			t.Fatalf("Delete(%s) found nothing", id)
+		}
+		if _, ok, _ := s.GetByID(ctx, id); ok {
+			t.Errorf("%s still found after Delete", id)
+		}
+	}
+	if ok, _ := s.Delete(ctx, "b"); ok {
+		t.Error("second Delete(b) reported a deletion")
+	}
+
+	all, _ := s.GetAll(ctx)
+	if len(all) != 2 || all[0].ID != "c" || all[1].ID != "d" {
+		t.Fatalf("movies after deletes = %v, want c, d", all)
+	}
+	if len(s.index) != len(all) {
+		t.Errorf("index holds %d IDs for %d movies", len(s.index), len(all))
+	}
+	for i, m := range all {
+		if j := s.index[m.ID]; j != i {
+			t.Errorf("index[%s] = %d, want %d", m.ID, j, i)
+		}
+	}
+	// Updates go through the index too, so they must hit the right movie.
+	c := all[0]
+	c.Title = "c2"
+	if _, ok, _ := s.Update(ctx, "c", c); !ok {
+		t.Fatal("Update(c) failed")
+	}
+	if got, _, _ := s.GetByID(ctx, "d"); got.Title != "d" {
+		t.Errorf("d's title = %q after updating c", got.Title)
+	}
+}
+
+// benchmarkStoreSize is how many movies the lookup benchmarks search.
+const benchmarkStoreSize = 10000
+
+// populatedStore returns a store holding n movies and the ID of the last.
+func populatedStore(b *testing.B, n int) (*memoryStore, string) {
+	b.Helper()
+	ctx := context.Background()
+	s := newMemoryStore(nil, 0)
+	var last Movie
+	for i := 0; i < n; i++ {
+		var err error
+		if last, err = s.Create(ctx, testMovie("", "Heat")); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return s, last.ID
+}
+
+// BenchmarkGetByIDScan is the lookup the store did before it kept an
+// index: a walk along the slice.
+func BenchmarkGetByIDScan(b *testing.B) {
+	s, id := populatedStore(b, benchmarkStoreSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.mu.RLock()
+		for _, m := range s.movies {
+			if m.ID == id {
+				break
+			}
+		}
+		s.mu.RUnlock()
+	}
+}
+
+func BenchmarkGetByIDIndex(b *testing.B) {
+	ctx := context.Background()
+	s, id := populatedStore(b, benchmarkStoreSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.GetByID(ctx, id)
+	}
+}
+
+// testCancelledStore checks that every read and write on s gives up with
+// context.Canceled once the context is cancelled, rather than passing the
+// failure off as an empty collection or a missing movie.
+func testCancelledStore(t *testing.T, s MovieStore) {
+	t.Helper()
+	created, err := s.Create(context.Background(), testMovie("", "Heat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := s.GetAll(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetAll: got %v, want context.Canceled", err)
+	}
+	if _, _, err := s.GetByID(ctx, created.ID); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetByID: got %v, want context.Canceled", err)
+	}
+	if _, _, err := s.Update(ctx, created.ID, created); !errors.Is(err, context.Canceled) {
+		t.Errorf("Update: got %v, want context.Canceled", err)
+	}
+	if _, err := s.Delete(ctx, created.ID); !errors.Is(err, context.Canceled) {
+		t.Errorf("Delete: got %v, want context.Canceled", err)
+	}
+	if _, ok, err := s.GetByID(context.Background(), created.ID); err != nil || !ok {
+		t.Errorf("movie after cancelled writes: found %v, err %v", ok, err)
+	}
+}
+
+func TestMemoryStoreCancelled(t *testing.T) {
+	testCancelledStore(t, newMemoryStore(nil, 0))
+}
+
+func TestMemoryStoreMaxMovies(t *testing.T) {
+	ctx := context.Background()
+	s := newMemoryStore(nil, 2)
+	for _, id := range []string{"a", "b"} {
+		if _, err := s.Create(ctx, testMovie(id, id)); err != nil {
+			t.Fatalf("create %s within the limit: %v", id, err)
+		}
+	}
+	if _, err := s.Create(ctx, testMovie("c", "c")); !errors.Is(err, ErrStoreFull) {
+		t.Errorf("create past the limit: got %v, want ErrStoreFull", err)
+	}
+	if _, _, err := s.Put(ctx, "c", testMovie("", "c")); !errors.Is(err, ErrStoreFull) {
+		t.Errorf("put creating past the limit: got %v, want ErrStoreFull", err)
+	}
+	if _, _, err := s.Put(ctx, "a", testMovie("", "a2")); err != nil {
+		t.Errorf("put replacing at the limit: %v", err)
+	}
+	if _, err := s.ReplaceAll(ctx, []Movie{testMovie("x", "x"), testMovie("y", "y"), testMovie("z", "z")}); !errors.Is(err, ErrStoreFull) {
+		t.Errorf("import past the limit: got %v, want ErrStoreFull", err)
+	}
+	if all, _ := s.GetAll(ctx); len(all) != 2 {
+		t.Errorf("store holds %d movies after rejected writes, want 2", len(all))
+	}
+}
+
+func TestMemoryStoreMaxMoviesPurgesDeleted(t *testing.T) {
+	ctx := context.Background()
+	s := newMemoryStore(nil, 3)
+	for _, id := range []string{"a", "b", "c"} {
+		s.Create(ctx, testMovie(id, id))
+	}
+	s.MarkDeleted(ctx, []string{"a", "b"}, time.Now())
+
+	// Two new movies fit once both deleted ones go, three never do, and
+	// a refused batch must not purge anything.
+	if _, err := s.CreateMany(ctx, []Movie{testMovie("d", "d"), testMovie("e", "e"), testMovie("f", "f")}); !errors.Is(err, ErrStoreFull) {
+		t.Fatalf("batch past the limit: got %v, want ErrStoreFull", err)
+	}
+	if _, ok, _ := s.GetByID(ctx, "a"); !ok {
+		t.Fatal("refused batch purged a deleted movie")
+	}
+	if _, err := s.Create(ctx, testMovie("d", "d")); err != nil {
+		t.Fatalf("create after deleting: %v", err)
+	}
+	if _, ok, _ := s.GetByID(ctx, "a"); ok {
+		t.Error("oldest deleted movie a was not purged")
+	}
+	if _, ok, _ := s.GetByID(ctx, "b"); !ok {
+		t.Error("deleted movie b was purged though one purge made room")
+	}
+	all, _ := s.GetAll(ctx)
+	for i, m := range all {
+		if got, _, _ := s.GetByID(ctx, m.ID); got.ID != m.ID {
+			t.Errorf("index for position %d points at %q, want %q", i, got.ID, m.ID)
+		}
+	}
+}
+
+func TestMaxMoviesBoundary(t *testing.T) {
+	ts := startServer(t, newMemoryStore(nil, 2), testConfig())
+	ts.create(movieJSON("Heat"))
+	last := ts.create(movieJSON("Ronin"))
+
+	resp := ts.do("POST", "/v1/movies", movieJSON("Thief"))
+	expectStatus(t, resp, http.StatusInsufficientStorage)
+	if got := decodeBody[errorBody](t, resp).Error.Code; got != "insufficient_storage" {
+		t.Errorf("error code = %q", got)
+	}
+	expectStatus(t, ts.do("PUT", "/v1/movies/thief", movieJSON("Thief")), http.StatusInsufficientStorage)
+	expectStatus(t, ts.do("POST", "/v1/movies/"+last.ID+"/duplicate", ""), http.StatusInsufficientStorage)
+
+	// Deleting a movie frees its place.
+	expectStatus(t, ts.do("DELETE", "/v1/movies/"+last.ID, ""), http.StatusOK)
+	ts.create(movieJSON("Thief"))
+	expectStatus(t, ts.do("POST", "/v1/movies/"+last.ID+"/restore", ""), http.StatusNotFound)
+}
+
+// fixedGenerator hands out the same ID every time.
+type fixedGenerator string
+
+func (g fixedGenerator) Next() string { return string(g) }
+
+func TestCreateMovieIDExhausted(t *testing.T) {
+	s := newMemoryStore(fixedGenerator("1"), 0)
+	ts := startServer(t, s, testConfig())
+	ts.create(movieJSON("Heat"))
+
+	resp := ts.do("POST", "/v1/movies", movieJSON("Ronin"))
+	expectStatus(t, resp, http.StatusInternalServerError)
+	if got := decodeBody[errorBody](t, resp).Error.Message; got != "Internal server error" {
+		t.Errorf("message = %q", got)
+	}
+	if all, _ := s.GetAll(context.Background()); len(all) != 1 {
+		t.Errorf("store holds %d movies, want 1", len(all))
+	}
+}
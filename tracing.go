@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the server's spans. It goes through the global provider,
+// which does nothing until setupTracing installs a real one.
+var tracer = otel.Tracer("CRUD")
+
+// setupTracing exports spans over OTLP/HTTP when cfg.OTLPEndpoint is set,
+// and leaves tracing a no-op otherwise. The exporter reads the rest of its
+// settings, such as OTEL_EXPORTER_OTLP_HEADERS, from the environment
+// itself. Either way incoming W3C trace context is honored. The returned
+// function flushes pending spans on shutdown.
+func setupTracing(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+	if cfg.OTLPEndpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// traceMiddleware wraps each request in a server span, continuing the
+// trace the caller's traceparent header names, if any. The span is named
+// after the route template rather than the path, so spans for different
+// movies group together.
+func traceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.Method
+		if route := mux.CurrentRoute(r); route != nil {
+			if tpl, err := route.GetPathTemplate(); err == nil {
+				name += " " + tpl
+			}
+		}
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("request.id", requestIDFromContext(r.Context())),
+			),
+		)
+		defer span.End()
+		rw := &responseWriter{ResponseWriter: w}
+		next.ServeHTTP(rw, r.WithContext(ctx))
+		if rw.status == 0 {
+			rw.status = http.StatusOK
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", rw.status))
+		if rw.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rw.status))
+		}
+	})
+}
+
+// tracedStore is a MovieStore that records a child span around every call
+// to the store it wraps.
+type tracedStore struct {
+	next MovieStore
+}
+
+// startStoreSpan starts the span for the store operation op.
+func startStoreSpan(ctx context.Context, op string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "MovieStore."+op, trace.WithAttributes(attrs...))
+}
+
+// endStoreSpan ends span, marking it failed if err is set.
+func endStoreSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (s tracedStore) GetAll(ctx context.Context) ([]Movie, error) {
+	ctx, span := startStoreSpan(ctx, "GetAll")
+	movies, err := s.next.GetAll(ctx)
+	span.SetAttributes(attribute.Int("movies.count", len(movies)))
+	endStoreSpan(span, err)
+	return movies, err
+}
+
+func (s tracedStore) GetByID(ctx context.Context, id string) (Movie, bool, error) {
+	ctx, span := startStoreSpan(ctx, "GetByID", attribute.String("movie.id", id))
+	movie, ok, err := s.next.GetByID(ctx, id)
+	span.SetAttributes(attribute.Bool("movie.found", ok))
+	endStoreSpan(span, err)
+	return movie, ok, err
+}
+
+func (s tracedStore) Create(ctx context.Context, movie Movie) (Movie, error) {
+	ctx, span := startStoreSpan(ctx, "Create")
+	created, err := s.next.Create(ctx, movie)
+	span.SetAttributes(attribute.String("movie.id", created.ID))
+	endStoreSpan(span, err)
+	return created, err
+}
+
+func (s tracedStore) CreateMany(ctx context.Context, movies []Movie) ([]Movie, error) {
+	ctx, span := startStoreSpan(ctx, "CreateMany", attribute.Int("movies.count", len(movies)))
+	created, err := s.next.CreateMany(ctx, movies)
+	endStoreSpan(span, err)
+	return created, err
+}
+
+func (s tracedStore) Update(ctx context.Context, id string, movie Movie) (Movie, bool, error) {
+	ctx, span := startStoreSpan(ctx, "Update", attribute.String("movie.id", id))
+	updated, ok, err := s.next.Update(ctx, id, movie)
+	span.SetAttributes(attribute.Bool("movie.updated", ok))
+	endStoreSpan(span, err)
+	return updated, ok, err
+}
+
+func (s tracedStore) Put(ctx context.Context, id string, movie Movie) (Movie, bool, error) {
+	ctx, span := startStoreSpan(ctx, "Put", attribute.String("movie.id", id))
+	stored, created, err := s.next.Put(ctx, id, movie)
+	span.SetAttributes(attribute.Bool("movie.created", created))
+	endStoreSpan(span, err)
+	return stored, created, err
+}
+
+func (s tracedStore) Delete(ctx context.Context, id string) (bool, error) {
+	ctx, span := startStoreSpan(ctx, "Delete", attribute.String("movie.id", id))
+	deleted, err := s.next.Delete(ctx, id)
+	endStoreSpan(span, err)
+	return deleted, err
+}
+
+func (s tracedStore) ReplaceAll(ctx context.Context, movies []Movie) ([]Movie, error) {
+	ctx, span := startStoreSpan(ctx, "ReplaceAll", attribute.Int("movies.count", len(movies)))
+	replaced, err := s.next.ReplaceAll(ctx, movies)
+	endStoreSpan(span, err)
+	return replaced, err
+}
+
+func (s tracedStore) MarkDeleted(ctx context.Context, ids []string, at time.Time) ([]string, []string, error) {
+	ctx, span := startStoreSpan(ctx, "MarkDeleted", attribute.Int("movies.count", len(ids)))
+	deleted, notFound, err := s.next.MarkDeleted(ctx, ids, at)
+	endStoreSpan(span, err)
+	return deleted, notFound, err
+}
+
+// Ping passes health checks through to the wrapped store, which is fine
+// when it has nothing to ping.
+func (s tracedStore) Ping() error {
+	if p, ok := s.next.(pinger); ok {
+		return p.Ping()
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	spansOnce sync.Once
+	spans     *tracetest.SpanRecorder
+)
+
+// recordSpans installs an in-memory span recorder as the global provider,
+// once, since tracer only takes up the first provider set. Tests tell
+// their spans apart by trace ID.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	spansOnce.Do(func() {
+		spans = tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+		if _, err := setupTracing(context.Background(), testConfig()); err != nil {
+			t.Fatal(err)
+		}
+	})
+	return spans
+}
+
+// endedSpans waits for the spans of trace id, which end after the client
+// has its response, and returns them once there are want of them.
+func endedSpans(t *testing.T, rec *tracetest.SpanRecorder, id trace.TraceID, want int) []sdktrace.ReadOnlySpan {
+	t.Helper()
+	var got []sdktrace.ReadOnlySpan
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		got = got[:0]
+		for _, s := range rec.Ended() {
+			if s.SpanContext().TraceID() == id {
+				got = append(got, s)
+			}
+		}
+		if len(got) >= want {
+			break
+		}
+	}
+	if len(got) != want {
+		names := make([]string, len(got))
+		for i, s := range got {
+			names[i] = s.Name()
+		}
+		t.Fatalf("trace %s has spans %v, want %d", id, names, want)
+	}
+	return got
+}
+
+func TestTracing(t *testing.T) {
+	rec := recordSpans(t)
+	ts := newTestServer(t, testConfig())
+	movie := ts.create(movieJSON("Heat"))
+
+	// The request continues the trace its traceparent names, which is
+	// random so reruns don't find the last run's spans.
+	var traceID trace.TraceID
+	var parentID trace.SpanID
+	rand.Read(traceID[:])
+	rand.Read(parentID[:])
+	resp := ts.do("GET", "/v1/movies/"+movie.ID, "", "traceparent", "00-"+traceID.String()+"-"+parentID.String()+"-01")
+	expectStatus(t, resp, http.StatusOK)
+
+	got := endedSpans(t, rec, traceID, 2)
+	var server, store sdktrace.ReadOnlySpan
+	for _, s := range got {
+		switch s.Name() {
+		case "GET /v1/movies/{id}":
+			server = s
+		case "MovieStore.GetByID":
+			store = s
+		}
+	}
+	if server == nil || store == nil {
+		t.Fatalf("spans %q and %q, want the request and its store call", got[0].Name(), got[1].Name())
+	}
+	if server.SpanKind() != trace.SpanKindServer || server.Parent().SpanID() != parentID || !server.Parent().IsRemote() {
+		t.Errorf("request span kind %v, parent %v", server.SpanKind(), server.Parent())
+	}
+	if store.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Errorf("store span parent %v, want the request span", store.Parent().SpanID())
+	}
+	if attrs := spanAttributes(server); attrs["http.response.status_code"] != "200" || attrs["url.path"] != "/v1/movies/"+movie.ID || attrs["request.id"] == "" {
+		t.Errorf("request span attributes = %v", attrs)
+	}
+
+	// Every request without a traceparent starts a trace of its own.
+	path := "/v1/movies/missing-" + parentID.String()
+	for i := 0; i < 2; i++ {
+		ts.do("GET", path, "")
+	}
+	traces := make(map[trace.TraceID]bool)
+	for deadline := time.Now().Add(time.Second); len(traces) < 2 && time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		for _, s := range rec.Ended() {
+			if s.SpanKind() == trace.SpanKindServer && spanAttributes(s)["url.path"] == path {
+				traces[s.SpanContext().TraceID()] = true
+			}
+		}
+	}
+	if len(traces) != 2 || traces[traceID] {
+		t.Errorf("two untraced requests made traces %v, want one new trace each", traces)
+	}
+}
+
+// spanAttributes returns the attributes of s as strings, by key.
+func spanAttributes(s sdktrace.ReadOnlySpan) map[string]string {
+	attrs := make(map[string]string)
+	for _, kv := range s.Attributes() {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	return attrs
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// FieldError describes why a single field of a request body was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// checkMovie normalizes movie, links its director and validates the
+// result, returning the field errors that should reject it. err is set
+// only when looking up the director failed.
+func (h *handler) checkMovie(ctx context.Context, movie *Movie) ([]FieldError, error) {
+	// DeletedAt is only ever set by deleteMovie.
+	movie.DeletedAt = nil
+	if id, ok := canonicalID(movie.ID); ok {
+		movie.ID = id
+	}
+	movie.ISBN = normalizeISBN(movie.ISBN)
+	movie.Genres = normalizeGenres(movie.Genres)
+	if errs, err := h.linkDirector(ctx, movie); errs != nil || err != nil {
+		return errs, err
+	}
+	return validateMovie(*movie), nil
+}
+
+// normalizeGenres trims each genre and drops repeats, comparing without
+// case and keeping the first spelling. Empty genres are kept for
+// validateMovie to reject.
+func normalizeGenres(genres []string) []string {
+	if genres == nil {
+		return nil
+	}
+	out := make([]string, 0, len(genres))
+	seen := make(map[string]bool, len(genres))
+	for _, g := range genres {
+		g = strings.TrimSpace(g)
+		key := strings.ToLower(g)
+		if g != "" && seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, g)
+	}
+	return out
+}
+
+// A movie's year must fall between minYear, when the first film was shot,
+// and maxYearsAhead years from now, which leaves room for announced
+// releases.
+const (
+	minYear       = 1888
+	maxYearsAhead = 5
+)
+
+// validateMovie checks the fields a stored movie must have and returns one
+// FieldError per problem, or nil if the movie is valid.
+func validateMovie(movie Movie) []FieldError {
+	var errs []FieldError
+	if movie.ID != "" {
+		if _, ok := canonicalID(movie.ID); !ok {
+			errs = append(errs, FieldError{Field: "id", Message: "id must be a UUID or up to 64 letters, digits, '-' or '_'"})
+		}
+	}
+	if strings.TrimSpace(movie.Title) == "" {
+		errs = append(errs, FieldError{Field: "title", Message: "title is required"})
+	}
+	if movie.ISBN == "" {
+		errs = append(errs, FieldError{Field: "isbn", Message: "isbn is required"})
+	} else if !isValidISBN(movie.ISBN) {
+		errs = append(errs, FieldError{Field: "isbn", Message: "isbn is not a valid ISBN-10 or ISBN-13"})
+	}
+	for _, g := range movie.Genres {
+		if strings.TrimSpace(g) == "" {
+			errs = append(errs, FieldError{Field: "genres", Message: "genres must not be empty strings"})
+			break
+		}
+	}
+	if movie.Year != 0 {
+		if latest := time.Now().Year() + maxYearsAhead; movie.Year < minYear || movie.Year > latest {
+			errs = append(errs, FieldError{Field: "year", Message: fmt.Sprintf("year must be between %d and %d", minYear, latest)})
+		}
+	}
+	if movie.Director == nil {
+		errs = append(errs, FieldError{Field: "director", Message: "director is required"})
+	} else {
+		for _, e := range validateDirector(*movie.Director) {
+			e.Field = "director." + e.Field
+			errs = append(errs, e)
+		}
+	}
+	return errs
+}
+
+// Policies for creating a movie whose title another movie already has.
+const (
+	duplicateTitleAllow  = "allow"
+	duplicateTitleWarn   = "warn"
+	duplicateTitleReject = "reject"
+)
+
+// duplicateTitleWarning is the Warning header sent under the warn policy.
+const duplicateTitleWarning = `199 - "a movie with this title already exists"`
+
+// duplicateTitles returns the indexes of the movies about to be created
+// whose title, ignoring case and surrounding space, is already taken by a
+// live movie or by an earlier movie in the same batch. It returns nil
+// without looking when duplicates are allowed.
+func (h *handler) duplicateTitles(ctx context.Context, movies []Movie) ([]int, error) {
+	if h.titlePolicy == duplicateTitleAllow {
+		return nil, nil
+	}
+	live, err := h.liveMovies(ctx)
+	if err != nil {
+		return nil, err
+	}
+	taken := make(map[string]bool)
+	for _, m := range live {
+		taken[strings.ToLower(strings.TrimSpace(m.Title))] = true
+	}
+	var dups []int
+	for i, m := range movies {
+		title := strings.ToLower(strings.TrimSpace(m.Title))
+		if taken[title] {
+			dups = append(dups, i)
+		}
+		taken[title] = true
+	}
+	return dups, nil
+}
+
+// maxIDLength bounds client-chosen movie IDs; generated UUIDs are 36
+// characters.
+const maxIDLength = 64
+
+// canonicalID reports whether id is a well-formed movie ID and returns it
+// in canonical form. UUIDs are lowercased so one movie can't be reached
+// under two spellings; any other ID must be letters, digits, '-' or '_'.
+func canonicalID(id string) (string, bool) {
+	if u, err := uuid.Parse(id); err == nil && len(id) == 36 {
+		return u.String(), true
+	}
+	if id == "" || len(id) > maxIDLength {
+		return "", false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return "", false
+		}
+	}
+	return id, true
+}
+
+// movieID returns the canonical {id} path parameter. A malformed ID gets a
+// 400 here, so a 404 from the handler always means a well-formed ID that
+// isn't stored.
+func movieID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id, ok := canonicalID(mux.Vars(r)["id"])
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, "Invalid movie ID")
+		return "", false
+	}
+	return id, true
+}
+
+// validateDirector checks that both of a director's names are present.
+func validateDirector(director Director) []FieldError {
+	var errs []FieldError
+	if strings.TrimSpace(director.FirstName) == "" {
+		errs = append(errs, FieldError{Field: "firstName", Message: "director first name is required"})
+	}
+	if strings.TrimSpace(director.LastName) == "" {
+		errs = append(errs, FieldError{Field: "lastName", Message: "director last name is required"})
+	}
+	return errs
+}
+
+// normalizeISBN strips the hyphens and spaces ISBNs are usually printed
+// with, so equal ISBNs are stored identically.
+func normalizeISBN(isbn string) string {
+	isbn = strings.NewReplacer("-", "", " ", "").Replace(isbn)
+	return strings.ToUpper(isbn)
+}
+
+// isValidISBN reports whether isbn is an ISBN-10 or ISBN-13 with a correct
+// check digit.
+func isValidISBN(isbn string) bool {
+	isbn = normalizeISBN(isbn)
+	switch len(isbn) {
+	case 10:
+		sum := 0
+		for i, c := range isbn {
+			var d int
+			switch {
+			case c >= '0' && c <= '9':
+				d = int(c - '0')
+			case c == 'X' && i == 9:
+				d = 10
+			default:
+				return false
+			}
+			sum += (10 - i) * d
+		}
+		return sum%11 == 0
+	case 13:
+		sum := 0
+		for i, c := range isbn {
+			if c < '0' || c > '9' {
+				return false
+			}
+			d := int(c - '0')
+			if i%2 == 1 {
+				d *= 3
+			}
+			sum += d
+		}
+		return sum%10 == 0
+	}
+	return false
+}
+
+// writeFieldErrors replies 422 with the list of field errors.
+func writeFieldErrors(w http.ResponseWriter, errs []FieldError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(errs)
+}
+
+// indexedFieldErrors are the field errors for one element of a bulk
+// request body.
+type indexedFieldErrors struct {
+	Index  int          `json:"index"`
+	Errors []FieldError `json:"errors"`
+}
+
+// validateMovieBody serves POST /movies/validate. It checks a movie the
+// way createMovie would, including the duplicate title policy, and answers
+// {"valid":true} or the field errors without storing anything, so forms
+// can validate as the user types.
+func (h *handler) validateMovieBody(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var movie Movie
+	if err := decodeJSONWithSchema(r, movieSchema, &movie); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	errs, err := h.checkMovie(r.Context(), &movie)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if errs != nil {
+		writeFieldErrors(w, errs)
+		return
+	}
+	dups, err := h.duplicateTitles(r.Context(), []Movie{movie})
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if h.titlePolicy == duplicateTitleReject && dups != nil {
+		writeFieldErrors(w, []FieldError{{Field: "title", Message: "a movie with this title already exists"}})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]bool{"valid": true})
+}
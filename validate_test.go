@@ -0,0 +1,264 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestValidateMovie(t *testing.T) {
+	valid := testMovie("", "Heat")
+	if errs := validateMovie(valid); errs != nil {
+		t.Fatalf("valid movie: %v", errs)
+	}
+	tests := []struct {
+		field  string
+		change func(*Movie)
+	}{
+		{"title", func(m *Movie) { m.Title = "  " }},
+		{"isbn", func(m *Movie) { m.ISBN = "" }},
+		{"isbn", func(m *Movie) { m.ISBN = "0306406153" }},
+		{"director", func(m *Movie) { m.Director = nil }},
+		{"director.firstName", func(m *Movie) { m.Director = &Director{LastName: "Mann"} }},
+		{"director.lastName", func(m *Movie) { m.Director = &Director{FirstName: "Michael"} }},
+	}
+	for _, tt := range tests {
+		movie := valid
+		tt.change(&movie)
+		errs := validateMovie(movie)
+		if len(errs) != 1 || errs[0].Field != tt.field {
+			t.Errorf("%+v: errors %v, want one for %s", movie, errs, tt.field)
+		}
+	}
+}
+
+func TestInvalidMovieRejected(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	id := ts.create(movieJSON("Heat")).ID
+	body := `{"isbn":"0306406152","title":"","director":{"firstName":"","lastName":"Mann"},"version":1}`
+	for _, method := range []string{"POST", "PUT"} {
+		path := "/v1/movies"
+		if method == "PUT" {
+			path += "/" + id
+		}
+		resp := ts.do(method, path, body)
+		expectStatus(t, resp, http.StatusUnprocessableEntity)
+		errs := decodeBody[[]FieldError](t, resp)
+		if len(errs) != 2 || errs[0].Field != "title" || errs[1].Field != "director.firstName" {
+			t.Errorf("%s: field errors = %v, want title and director.firstName", method, errs)
+		}
+	}
+	resp := ts.do("GET", "/v1/movies/"+id, "")
+	if got := decodeBody[Movie](t, resp).Title; got != "Heat" {
+		t.Errorf("title after rejected PUT = %q, want Heat", got)
+	}
+}
+
+func TestIsValidISBN(t *testing.T) {
+	tests := []struct {
+		isbn string
+		want bool
+	}{
+		{"0306406152", true},
+		{"0-306-40615-2", true},
+		{"080442957X", true},
+		{"080442957x", true},
+		{"9780306406157", true},
+		{"978-0-306-40615-7", true},
+		{"0306406153", false},
+		{"9780306406158", false},
+		{"030640615", false},
+		{"97803064061570", false},
+		{"X306406152", false},
+		{"978030640615X", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := isValidISBN(tt.isbn); got != tt.want {
+			t.Errorf("isValidISBN(%q) = %v, want %v", tt.isbn, got, tt.want)
+		}
+	}
+}
+
+func TestInvalidISBNRejected(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	id := ts.create(movieJSON("Heat")).ID
+	body := `{"isbn":"978-0-306-40615-8","title":"Heat","director":{"firstName":"Michael","lastName":"Mann"},"version":1}`
+	for _, req := range []struct{ method, path string }{
+		{"POST", "/v1/movies"},
+		{"PUT", "/v1/movies/" + id},
+	} {
+		resp := ts.do(req.method, req.path, body)
+		expectStatus(t, resp, http.StatusUnprocessableEntity)
+		if errs := decodeBody[[]FieldError](t, resp); len(errs) != 1 || errs[0].Field != "isbn" {
+			t.Errorf("%s: errors %v, want one for isbn", req.method, errs)
+		}
+	}
+}
+
+func TestCanonicalID(t *testing.T) {
+	tests := []struct {
+		id, want string
+		ok       bool
+	}{
+		{"1", "1", true},
+		{"movie_2-b", "movie_2-b", true},
+		{"3F2504E0-4F89-41D3-9A0C-0305E82C3301", "3f2504e0-4f89-41d3-9a0c-0305e82c3301", true},
+		{"", "", false},
+		{"a.b", "", false},
+		{"a%20b", "", false},
+		{strings.Repeat("a", maxIDLength), strings.Repeat("a", maxIDLength), true},
+		{strings.Repeat("a", maxIDLength+1), "", false},
+	}
+	for _, tt := range tests {
+		got, ok := canonicalID(tt.id)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("canonicalID(%q) = %q, %v; want %q, %v", tt.id, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestMalformedMovieID(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	movie := ts.create(movieJSON("Heat"))
+	for _, req := range []struct{ method, body string }{
+		{"GET", ""},
+		{"PUT", movieJSON("Heat")},
+		{"PATCH", `{"version":1}`},
+		{"DELETE", ""},
+	} {
+		expectStatus(t, ts.do(req.method, "/v1/movies/bad.id", req.body), http.StatusBadRequest)
+		expectStatus(t, ts.do(req.method, "/v1/movies/"+strings.Repeat("a", maxIDLength+1), req.body), http.StatusBadRequest)
+		if req.method != "PUT" {
+			expectStatus(t, ts.do(req.method, "/v1/movies/missing", req.body), http.StatusNotFound)
+		}
+	}
+	// Another spelling of a UUID reaches the same movie.
+	resp := ts.do("GET", "/v1/movies/"+strings.ToUpper(movie.ID), "")
+	expectStatus(t, resp, http.StatusOK)
+	if got := decodeBody[Movie](t, resp).ID; got != movie.ID {
+		t.Errorf("upper-case ID fetched %q, want %q", got, movie.ID)
+	}
+}
+
+func TestDuplicateTitlePolicy(t *testing.T) {
+	tests := []struct {
+		policy  string
+		status  int
+		warning string
+	}{
+		{duplicateTitleAllow, http.StatusCreated, ""},
+		{duplicateTitleWarn, http.StatusCreated, duplicateTitleWarning},
+		{duplicateTitleReject, http.StatusUnprocessableEntity, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.DuplicateTitlePolicy = tt.policy
+			ts := newTestServer(t, cfg)
+			ts.create(movieJSON("Heat"))
+
+			// Titles match ignoring case and surrounding space.
+			resp := ts.do("POST", "/v1/movies", movieJSON(" heat "))
+			expectStatus(t, resp, tt.status)
+			if got := resp.Header.Get("Warning"); got != tt.warning {
+				t.Errorf("Warning = %q, want %q", got, tt.warning)
+			}
+			if tt.status == http.StatusUnprocessableEntity {
+				errs := decodeBody[[]FieldError](t, resp)
+				if len(errs) != 1 || errs[0].Field != "title" {
+					t.Errorf("field errors = %v, want one for title", errs)
+				}
+			}
+		})
+	}
+}
+
+func TestDuplicateTitleRejectReplaysIdempotentRetry(t *testing.T) {
+	cfg := testConfig()
+	cfg.DuplicateTitlePolicy = duplicateTitleReject
+	ts := newTestServer(t, cfg)
+
+	resp := ts.do("POST", "/v1/movies", movieJSON("Heat"), "Idempotency-Key", "k1")
+	expectStatus(t, resp, http.StatusCreated)
+	first := decodeBody[Movie](t, resp)
+
+	// The retry's title is taken by the movie its first attempt created.
+	for _, query := range []string{"", "?dry_run=true"} {
+		resp = ts.do("POST", "/v1/movies"+query, movieJSON("Heat"), "Idempotency-Key", "k1")
+		expectStatus(t, resp, http.StatusOK)
+		if got := decodeBody[Movie](t, resp).ID; got != first.ID {
+			t.Errorf("retry%s returned movie %s, want %s", query, got, first.ID)
+		}
+	}
+}
+
+func TestISBNRoundTrip(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	for _, tt := range []struct{ sent, stored string }{
+		{"978-0-306-40615-7", "9780306406157"},
+		{"9791090636071", "9791090636071"},
+		// A leading zero an integer would have dropped.
+		{"0306406152", "0306406152"},
+	} {
+		created := ts.create(`{"isbn":"` + tt.sent + `","title":"Heat","director":{"firstName":"Michael","lastName":"Mann"}}`)
+		if created.ISBN != tt.stored {
+			t.Errorf("created ISBN for %s = %q, want %q", tt.sent, created.ISBN, tt.stored)
+		}
+		resp := ts.do("GET", "/v1/movies/"+created.ID, "")
+		expectStatus(t, resp, http.StatusOK)
+		if got := decodeBody[Movie](t, resp).ISBN; got != tt.stored {
+			t.Errorf("fetched ISBN for %s = %q, want %q", tt.sent, got, tt.stored)
+		}
+	}
+}
+
+func TestNumericISBNRejected(t *testing.T) {
+	ts := newTestServer(t, testConfig())
+	// ISBNs are strings; a number is a type error, not one to convert.
+	resp := ts.do("POST", "/v1/movies", `{"isbn":9780306406157,"title":"Heat","director":{"firstName":"Michael","lastName":"Mann"}}`)
+	expectStatus(t, resp, http.StatusUnprocessableEntity)
+	errs := decodeBody[[]FieldError](t, resp)
+	if len(errs) != 1 || errs[0].Field != "isbn" {
+		t.Errorf("field errors = %v, want one for isbn", errs)
+	}
+}
+
+func TestValidateEndpoint(t *testing.T) {
+	cfg := testConfig()
+	cfg.DuplicateTitlePolicy = duplicateTitleReject
+	ts := newTestServer(t, cfg)
+	ts.create(movieJSON("Heat"))
+
+	resp := ts.do("POST", "/v1/movies/validate", movieJSON("Ronin"))
+	expectStatus(t, resp, http.StatusOK)
+	if got := decodeBody[map[string]bool](t, resp); len(got) != 1 || !got["valid"] {
+		t.Errorf("valid payload: body = %v", got)
+	}
+
+	tests := []struct {
+		body   string
+		fields []string
+	}{
+		{`{"isbn":"0306406153","title":"","director":{"firstName":"Michael","lastName":"Mann"}}`, []string{"title", "isbn"}},
+		{`{"isbn":"0306406152","title":"Ronin","year":"1998","director":{"firstName":"John","lastName":"Frankenheimer"}}`, []string{"year"}},
+		{movieJSON(" HEAT "), []string{"title"}},
+	}
+	for _, tt := range tests {
+		resp := ts.do("POST", "/v1/movies/validate", tt.body)
+		expectStatus(t, resp, http.StatusUnprocessableEntity)
+		var fields []string
+		for _, e := range decodeBody[[]FieldError](t, resp) {
+			fields = append(fields, e.Field)
+		}
+		if strings.Join(fields, ",") != strings.Join(tt.fields, ",") {
+			t.Errorf("%s: errors for %v, want %v", tt.body, fields, tt.fields)
+		}
+	}
+	expectStatus(t, ts.do("POST", "/v1/movies/validate", `{"title":`), http.StatusBadRequest)
+
+	// Validating never stores anything.
+	if got := ts.list("").Total; got != 1 {
+		t.Errorf("total after validating = %d, want 1", got)
+	}
+}
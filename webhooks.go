@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// WebhookEvent is the body POSTed to every webhook URL when a movie
+// changes. Type and Movie are as in MovieEvent.
+type WebhookEvent struct {
+	Type      string    `json:"type"`
+	Movie     *Movie    `json:"movie,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// webhookDispatcher delivers events to the configured webhook URLs in the
+// background, so a slow or failing receiver never holds up the request
+// that made the change. Deliveries still pending at shutdown are dropped.
+type webhookDispatcher struct {
+	client      *http.Client
+	urls        []string
+	maxAttempts int
+	ctx         context.Context
+}
+
+// newWebhookDispatcher returns a dispatcher posting to urls, giving each
+// attempt timeout and each delivery up to maxAttempts tries. It stops
+// retrying once ctx is done.
+func newWebhookDispatcher(ctx context.Context, urls []string, timeout time.Duration, maxAttempts int) *webhookDispatcher {
+	return &webhookDispatcher{
+		client:      &http.Client{Timeout: timeout},
+		urls:        urls,
+		maxAttempts: maxAttempts,
+		ctx:         ctx,
+	}
+}
+
+// dispatch starts delivering event to every URL and returns at once.
+func (d *webhookDispatcher) dispatch(event WebhookEvent) {
+	if len(d.urls) == 0 {
+		return
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		panic(err) // WebhookEvent always marshals.
+	}
+	for _, url := range d.urls {
+		go d.deliver(url, event.Type, body)
+	}
+}
+
+// deliver POSTs body to url until a 2xx answer, waiting a second longer
+// after each failed attempt, and logs the delivery as failed once
+// maxAttempts have been used up.
+func (d *webhookDispatcher) deliver(url, eventType string, body []byte) {
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = d.post(url, body); err == nil {
+			return
+		}
+		if attempt == d.maxAttempts {
+			break
+		}
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-time.After(time.Duration(attempt) * time.Second):
+		}
+	}
+	slog.Warn("webhook delivery failed",
+		"url", url,
+		"event", eventType,
+		"attempts", d.maxAttempts,
+		"error", err,
+	)
+}
+
+func (d *webhookDispatcher) post(url string, body []byte) error {
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// webhookReceiver starts a server that answers each POST with the next of
+// statuses, then 200, and passes every event it decodes to the channel.
+func webhookReceiver(t *testing.T, statuses ...int) (*httptest.Server, <-chan WebhookEvent) {
+	t.Helper()
+	events := make(chan WebhookEvent, 10)
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("webhook got %s with Content-Type %q", r.Method, r.Header.Get("Content-Type"))
+		}
+		var event WebhookEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("webhook body: %v", err)
+		}
+		events <- event
+		if n := int(calls.Add(1)); n <= len(statuses) {
+			w.WriteHeader(statuses[n-1])
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, events
+}
+
+func nextWebhook(t *testing.T, events <-chan WebhookEvent) WebhookEvent {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not called")
+		return WebhookEvent{}
+	}
+}
+
+func TestWebhookOnCreate(t *testing.T) {
+	receiver, events := webhookReceiver(t)
+	cfg := testConfig()
+	cfg.WebhookURLs = []string{receiver.URL}
+	ts := newTestServer(t, cfg)
+
+	before := time.Now()
+	movie := ts.create(movieJSON("Heat"))
+	event := nextWebhook(t, events)
+	if event.Type != auditCreate || event.Movie == nil || event.Movie.ID != movie.ID || event.Movie.Title != "Heat" {
+		t.Errorf("event = %+v, want create of %s", event, movie.ID)
+	}
+	if event.Timestamp.Before(before.Truncate(time.Second)) || event.Timestamp.After(time.Now()) {
+		t.Errorf("timestamp %v outside the request", event.Timestamp)
+	}
+
+	ts.do("DELETE", "/v1/movies/"+movie.ID, "")
+	if event := nextWebhook(t, events); event.Type != auditDelete || event.Movie.ID != movie.ID {
+		t.Errorf("event = %+v, want delete of %s", event, movie.ID)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	receiver, events := webhookReceiver(t, http.StatusInternalServerError)
+	cfg := testConfig()
+	cfg.WebhookURLs = []string{receiver.URL}
+	cfg.WebhookMaxAttempts = 2
+	ts := newTestServer(t, cfg)
+
+	movie := ts.create(movieJSON("Heat"))
+	first, retry := nextWebhook(t, events), nextWebhook(t, events)
+	if first.Movie.ID != movie.ID || retry.Movie.ID != movie.ID {
+		t.Errorf("deliveries %+v and %+v, want both for %s", first, retry, movie.ID)
+	}
+	select {
+	case event := <-events:
+		t.Errorf("delivered again after success: %+v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}